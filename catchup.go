@@ -0,0 +1,177 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/sebdeveloper6952/godvm/checkpoints"
+)
+
+const defaultCatchUpLookback = time.Hour
+
+type CatchUpConfig struct {
+	Store checkpoints.Store
+
+	// Lookback bounds how far back requests are fetched, however old the checkpoint of a relay is. Defaults to an
+	// hour.
+	Lookback time.Duration
+}
+
+// EnableCatchUp makes the engine serve the job requests published while it was not running. Requests older than
+// the start of the engine are skipped when a registered DVM already answered them, or when they expired (NIP-40).
+// It must be called before Run.
+func (e *Engine) EnableCatchUp(cfg CatchUpConfig) {
+	if cfg.Lookback <= 0 {
+		cfg.Lookback = defaultCatchUpLookback
+	}
+	e.nostrSvc.SetCatchUp(cfg.Store, cfg.Lookback)
+}
+
+// skipJobRequest reports whether the job request must not be served, because it expired or, when it was published
+// before the engine started, because one of dvms already answered it.
+func (e *Engine) skipJobRequest(ctx context.Context, event *goNostr.Event, dvms []Dvmer) bool {
+	if requestExpired(event) {
+		e.log.Printf("job request %s expired", event.ID)
+		return true
+	}
+
+	if !event.CreatedAt.Time().Before(e.startedAt) {
+		return false
+	}
+
+	authors := make([]string, 0, len(dvms))
+	for i := range dvms {
+		authors = append(authors, dvms[i].PublicKeyHex())
+	}
+
+	_, err := e.nostrSvc.FetchLatestEvent(ctx, goNostr.Filter{
+		Kinds:   []int{KindJobFeedback, event.Kind + 1000},
+		Authors: authors,
+		Tags:    goNostr.TagMap{"e": []string{event.ID}},
+		Limit:   1,
+	})
+	if errors.Is(err, ErrEventNotFound) {
+		return false
+	}
+	if err != nil {
+		e.log.Printf("look for answers to job request %s %+v", event.ID, err)
+		return false
+	}
+
+	e.log.Printf("job request %s was already answered", event.ID)
+	return true
+}
+
+// requestExpired reports whether the event has a NIP-40 expiration tag in the past.
+func requestExpired(event *goNostr.Event) bool {
+	tag := event.Tags.GetFirst([]string{"expiration", ""})
+	if tag == nil {
+		return false
+	}
+
+	expiration, err := strconv.ParseInt(tag.Value(), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return time.Unix(expiration, 0).Before(time.Now())
+}
+
+// requestTracker tells how far the checkpoint of each relay can safely move: up to the oldest job request it sent
+// that is not handled yet, and not at all while it's still sending its stored requests, which come newest first.
+type requestTracker struct {
+	mu     sync.Mutex
+	relays map[string]*relayRequests
+}
+
+type relayRequests struct {
+	// live is set once the relay sent all its stored requests, and unset when the subscription goes down.
+	live    bool
+	latest  time.Time
+	pending map[string]time.Time
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{
+		relays: make(map[string]*relayRequests),
+	}
+}
+
+// relay must be called with t.mu held.
+func (t *requestTracker) relay(url string) *relayRequests {
+	r, ok := t.relays[url]
+	if !ok {
+		r = &relayRequests{pending: make(map[string]time.Time)}
+		t.relays[url] = r
+	}
+
+	return r
+}
+
+// received records a job request sent by the relay. Pending requests hold back its checkpoint until handled.
+// Creation times in the future are recorded as now, so a skewed clock can't make later requests be skipped.
+func (t *requestTracker) received(url string, event *goNostr.Event, pending bool) {
+	createdAt := event.CreatedAt.Time()
+	if now := time.Now(); createdAt.After(now) {
+		createdAt = now
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.relay(url)
+	r.latest = maxTime(r.latest, createdAt)
+	if pending {
+		r.pending[event.ID] = createdAt
+	}
+}
+
+// handled marks the job request as handled, and returns the checkpoints of the relays that sent it.
+func (t *requestTracker) handled(id string) map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	advanced := make(map[string]time.Time)
+	for url, r := range t.relays {
+		if _, ok := r.pending[id]; !ok {
+			continue
+		}
+		delete(r.pending, id)
+		if checkpoint, ok := r.checkpoint(); ok {
+			advanced[url] = checkpoint
+		}
+	}
+
+	return advanced
+}
+
+// setLive records whether the relay is done sending its stored requests, and returns its checkpoint once it is.
+func (t *requestTracker) setLive(url string, live bool) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.relay(url)
+	r.live = live
+
+	return r.checkpoint()
+}
+
+// checkpoint must be called with the tracker lock held.
+func (r *relayRequests) checkpoint() (time.Time, bool) {
+	if !r.live || r.latest.IsZero() {
+		return time.Time{}, false
+	}
+
+	checkpoint := r.latest
+	for _, createdAt := range r.pending {
+		if createdAt.Before(checkpoint) {
+			checkpoint = createdAt
+		}
+	}
+
+	return checkpoint, true
+}
@@ -0,0 +1,82 @@
+package godvm
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+func TestRequestTracker(t *testing.T) {
+	at := func(seconds int64) time.Time { return time.Unix(seconds, 0) }
+	request := func(id string, seconds int64) *goNostr.Event {
+		return &goNostr.Event{ID: id, CreatedAt: goNostr.Timestamp(seconds)}
+	}
+
+	tr := newRequestTracker()
+
+	// stored requests come newest first, and nothing moves until the relay is live.
+	tr.received("r1", request("new", 200), true)
+	tr.received("r1", request("old", 100), true)
+	tr.received("r1", request("dup", 150), false)
+	if got := tr.handled("new"); len(got) != 0 {
+		t.Fatalf("checkpoint advanced before the relay is live: %v", got)
+	}
+	if checkpoint, ok := tr.setLive("r1", true); !ok || !checkpoint.Equal(at(100)) {
+		t.Fatalf("got checkpoint %v %v, want %v", checkpoint, ok, at(100))
+	}
+
+	// the request is also pending for another relay, which is live with nothing else pending.
+	tr.received("r2", request("old", 100), true)
+	tr.setLive("r2", true)
+	got := tr.handled("old")
+	if len(got) != 2 || !got["r1"].Equal(at(200)) || !got["r2"].Equal(at(100)) {
+		t.Fatalf("got checkpoints %v", got)
+	}
+
+	if _, ok := tr.setLive("r1", false); ok {
+		t.Fatal("checkpoint returned while the relay is down")
+	}
+	if _, ok := tr.setLive("unknown", true); ok {
+		t.Fatal("checkpoint returned for a relay that sent no request")
+	}
+}
+
+func TestRequestTrackerClampsFutureRequests(t *testing.T) {
+	tr := newRequestTracker()
+	tr.setLive("r", true)
+
+	future := time.Now().Add(time.Hour)
+	tr.received("r", &goNostr.Event{ID: "a", CreatedAt: goNostr.Timestamp(future.Unix())}, false)
+
+	checkpoint, ok := tr.setLive("r", true)
+	if !ok || !checkpoint.Before(future.Add(-time.Minute)) {
+		t.Fatalf("got checkpoint %v %v, want about now", checkpoint, ok)
+	}
+}
+
+func TestRequestExpired(t *testing.T) {
+	expiration := func(at time.Time) goNostr.Tags {
+		return goNostr.Tags{{"expiration", strconv.FormatInt(at.Unix(), 10)}}
+	}
+
+	tests := []struct {
+		name string
+		tags goNostr.Tags
+		want bool
+	}{
+		{"no expiration", nil, false},
+		{"past", expiration(time.Now().Add(-time.Minute)), true},
+		{"future", expiration(time.Now().Add(time.Minute)), false},
+		{"invalid", goNostr.Tags{{"expiration", "soon"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestExpired(&goNostr.Event{Tags: tt.tags}); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
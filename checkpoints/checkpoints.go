@@ -0,0 +1,16 @@
+// Package checkpoints keeps, for every relay, the creation time of the newest job request received from it, so the
+// requests published while the engine was offline can be fetched when it runs again.
+package checkpoints
+
+import (
+	"context"
+	"time"
+)
+
+type Store interface {
+	// Get returns the checkpoint of the relay, the zero time when it has none.
+	Get(ctx context.Context, relayURL string) (time.Time, error)
+
+	// Advance moves the checkpoint of the relay to createdAt, unless it's already later.
+	Advance(ctx context.Context, relayURL string, createdAt time.Time) error
+}
@@ -0,0 +1,60 @@
+package checkpoints
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sebdeveloper6952/godvm/internal/filestore"
+)
+
+type memory struct {
+	mu     sync.Mutex
+	path   string
+	relays map[string]time.Time
+}
+
+// NewMemory returns a Store that only lives in memory.
+func NewMemory() Store {
+	return &memory{
+		relays: make(map[string]time.Time),
+	}
+}
+
+// NewFile returns a Store kept in memory and saved as JSON to path after every change. Existing checkpoints in
+// path are loaded first.
+func NewFile(path string) (Store, error) {
+	m := &memory{
+		path:   path,
+		relays: make(map[string]time.Time),
+	}
+
+	if err := filestore.Load(path, &m.relays); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *memory) Get(ctx context.Context, relayURL string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.relays[relayURL], nil
+}
+
+func (m *memory) Advance(ctx context.Context, relayURL string, createdAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !createdAt.After(m.relays[relayURL]) {
+		return nil
+	}
+	m.relays[relayURL] = createdAt
+
+	if m.path == "" {
+		return nil
+	}
+
+	return filestore.Save(m.path, m.relays)
+}
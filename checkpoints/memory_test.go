@@ -0,0 +1,53 @@
+package checkpoints
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdvance(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	store, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		relay string
+		at    int64
+		want  int64
+	}{
+		{"r1", 100, 100},
+		{"r1", 200, 200},
+		{"r1", 150, 200},
+		{"r2", 50, 50},
+	}
+
+	for i, s := range steps {
+		if err := store.Advance(ctx, s.relay, time.Unix(s.at, 0)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Get(ctx, s.relay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(time.Unix(s.want, 0)) {
+			t.Errorf("step %d: got checkpoint %v, want %v", i, got.Unix(), s.want)
+		}
+	}
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reopened.Get(ctx, "r1"); !got.Equal(time.Unix(200, 0)) {
+		t.Errorf("got checkpoint %v after reopening, want 200", got.Unix())
+	}
+	if got, _ := reopened.Get(ctx, "unknown"); !got.IsZero() {
+		t.Errorf("got checkpoint %v for an unknown relay, want the zero time", got)
+	}
+}
@@ -0,0 +1,191 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/sebdeveloper6952/godvm/credits"
+	"github.com/sebdeveloper6952/godvm/invoices"
+	"github.com/sebdeveloper6952/godvm/ledger"
+	"github.com/sebdeveloper6952/godvm/lightning"
+)
+
+var (
+	ErrCreditsDisabled = errors.New("credits are not enabled")
+)
+
+type CreditsConfig struct {
+	Store credits.Store
+
+	// ZapperPubkeys are the nostr public keys of the LNURL servers whose zap receipts are trusted. Zaps to any
+	// registered DVM confirmed by one of them are credited to the zap sender. When empty, zaps are ignored and
+	// balances can only be topped up with Engine.TopUp.
+	ZapperPubkeys []string
+}
+
+// EnableCredits makes the engine pay jobs from the customer balance when it's enough to cover the amount a DVM
+// asks for with StatusPaymentRequired, instead of issuing an invoice.
+func (e *Engine) EnableCredits(cfg CreditsConfig) {
+	e.credits = &cfg
+}
+
+// Balance returns the prepaid balance of the customer.
+func (e *Engine) Balance(ctx context.Context, customerPubkey string) (int64, error) {
+	if e.credits == nil {
+		return 0, ErrCreditsDisabled
+	}
+
+	return e.credits.Store.Balance(ctx, customerPubkey)
+}
+
+// TopUp issues an invoice that credits amountSats to the customer balance once paid. The invoice is watched until
+// it settles or expires.
+func (e *Engine) TopUp(ctx context.Context, customerPubkey string, amountSats int64) (*lightning.Invoice, error) {
+	if e.credits == nil {
+		return nil, ErrCreditsDisabled
+	}
+	if e.lnSvc == nil {
+		return nil, ErrNoLnService
+	}
+
+	invoice, err := e.lnSvc.AddInvoice(ctx, amountSats)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := invoice.Hash.String()
+	e.savePendingInvoice(ctx, &invoices.Pending{
+		Hash:           hash,
+		PayReq:         invoice.PayReq,
+		Purpose:        invoices.PurposeTopUp,
+		CustomerPubkey: customerPubkey,
+		AmountSats:     amountSats,
+		CreatedAt:      time.Now(),
+	})
+
+	e.watcher.watch(e.lnSvc, invoice, func(err error) {
+		e.deletePendingInvoice(hash)
+		if err != nil {
+			e.log.Printf("top-up invoice %s not paid %+v", hash, err)
+			return
+		}
+		e.creditTopUp(context.Background(), customerPubkey, amountSats, hash)
+	})
+
+	return invoice, nil
+}
+
+func (e *Engine) creditTopUp(ctx context.Context, customerPubkey string, amountSats int64, hash string) {
+	if e.credits == nil {
+		e.log.Printf("top-up invoice %s paid with credits disabled", hash)
+		return
+	}
+
+	balance, err := e.credits.Store.Credit(ctx, customerPubkey, amountSats, hash)
+	if err != nil {
+		e.log.Printf("credit top-up %s %+v", hash, err)
+		return
+	}
+	e.log.Printf("topped up %d sats for %s, balance %d", amountSats, customerPubkey, balance)
+}
+
+// listenForZaps credits the senders of zaps to the registered DVMs.
+func (e *Engine) listenForZaps(ctx context.Context) {
+	if e.credits == nil || len(e.credits.ZapperPubkeys) == 0 {
+		return
+	}
+
+	dvmPubkeys := make([]string, 0, len(e.dvmsByKind))
+	for _, dvms := range e.dvmsByKind {
+		for i := range dvms {
+			dvmPubkeys = append(dvmPubkeys, dvms[i].PublicKeyHex())
+		}
+	}
+
+	now := goNostr.Now()
+	receipts, err := e.nostrSvc.Subscribe(ctx, goNostr.Filters{
+		{
+			Kinds:   []int{KindZapReceipt},
+			Authors: e.credits.ZapperPubkeys,
+			Tags:    goNostr.TagMap{"p": dvmPubkeys},
+			Since:   &now,
+		},
+	})
+	if err != nil {
+		e.log.Printf("subscribe to zap receipts %+v", err)
+		return
+	}
+
+	for receipt := range receipts {
+		zap, err := creditableZap(receipt, e.credits.ZapperPubkeys, dvmPubkeys)
+		if err != nil {
+			e.log.Printf("zap receipt %s %+v", receipt.ID, err)
+			continue
+		}
+
+		balance, err := e.credits.Store.Credit(ctx, zap.SenderPubkey, zap.AmountMsats/1000, zap.PaymentHash)
+		if err != nil {
+			e.log.Printf("credit zap %s %+v", receipt.ID, err)
+			continue
+		}
+		e.log.Printf("credited zap of %d msats from %s, balance %d", zap.AmountMsats, zap.SenderPubkey, balance)
+	}
+}
+
+// creditableZap returns the zap proved by the receipt, when one of the trusted zappers signed it and it paid one of
+// the DVMs. Relays are not trusted to honour the authors and `p` filters.
+func creditableZap(receipt *goNostr.Event, zapperPubkeys []string, dvmPubkeys []string) (*Zap, error) {
+	zap, err := ZapFromReceipt(receipt)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(zapperPubkeys, receipt.PubKey) {
+		return nil, fmt.Errorf("%w: not signed by a trusted zapper", ErrInvalidZapReceipt)
+	}
+	if !slices.Contains(dvmPubkeys, zap.RecipientPubkey) {
+		return nil, fmt.Errorf("%w: zap to %s, not to a DVM", ErrInvalidZapReceipt, zap.RecipientPubkey)
+	}
+
+	return zap, nil
+}
+
+// payWithCredits debits the job amount from the customer balance. It reports false when credits are disabled or
+// the balance is not enough, and the job must be paid with an invoice instead. Every call is a separate charge.
+func (e *Engine) payWithCredits(ctx context.Context, run *jobRun, amountSats int64) (int64, bool) {
+	if e.credits == nil || amountSats <= 0 {
+		return 0, false
+	}
+
+	balance, err := e.credits.Store.Debit(
+		ctx,
+		run.input.CustomerPubkey,
+		amountSats,
+		fmt.Sprintf("%s:%s:%d", run.input.JobRequestId, run.dvm.PublicKeyHex(), run.charges.Add(1)),
+	)
+	if err != nil {
+		if !errors.Is(err, credits.ErrInsufficientBalance) {
+			e.log.Printf("debit credits %+v", err)
+		}
+		return balance, false
+	}
+
+	run.paidSats.Add(amountSats)
+	run.paidCreditsSats.Add(amountSats)
+	e.recordJob(ctx, run.dvm, run.input, func(entry *ledger.Entry) {
+		entry.AmountRequestedSats += amountSats
+		entry.AmountPaidSats += amountSats
+		entry.PaidAt = time.Now()
+		entry.PaidWithCredits = true
+	})
+
+	return balance, true
+}
+
+// balanceTag is added to feedback events of jobs paid with credits.
+func balanceTag(balanceSats int64) []string {
+	return []string{"balance", fmt.Sprintf("%d", balanceSats*1000)}
+}
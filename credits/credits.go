@@ -0,0 +1,26 @@
+// Package credits keeps prepaid sat balances per customer public key, so customers can top up once and have jobs
+// paid from their balance instead of paying an invoice per job.
+package credits
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrInvalidAmount       = errors.New("amount must be positive")
+)
+
+type Store interface {
+	// Balance returns the balance of the public key, zero if it never topped up.
+	Balance(ctx context.Context, pubkey string) (int64, error)
+
+	// Credit adds amountSats to the balance and returns the new balance. ref identifies the top-up, e.g. the
+	// payment hash that funded it: crediting the same ref twice only credits once.
+	Credit(ctx context.Context, pubkey string, amountSats int64, ref string) (int64, error)
+
+	// Debit subtracts amountSats from the balance and returns the new balance, or ErrInsufficientBalance. Like
+	// Credit, debiting the same ref twice only debits once.
+	Debit(ctx context.Context, pubkey string, amountSats int64, ref string) (int64, error)
+}
@@ -0,0 +1,98 @@
+package credits
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sebdeveloper6952/godvm/internal/filestore"
+)
+
+type document struct {
+	Balances map[string]int64    `json:"balances"`
+	Refs     map[string]struct{} `json:"refs"`
+}
+
+type memory struct {
+	mu   sync.Mutex
+	path string
+	doc  *document
+}
+
+// NewMemory returns a Store that only lives in memory.
+func NewMemory() Store {
+	return &memory{
+		doc: newDocument(),
+	}
+}
+
+// NewFile returns a Store kept in memory and saved as JSON to path after every change. Existing balances in path
+// are loaded first.
+func NewFile(path string) (Store, error) {
+	m := &memory{
+		path: path,
+		doc:  newDocument(),
+	}
+
+	if err := filestore.Load(path, m.doc); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *memory) Balance(ctx context.Context, pubkey string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.doc.Balances[pubkey], nil
+}
+
+func (m *memory) Credit(ctx context.Context, pubkey string, amountSats int64, ref string) (int64, error) {
+	if amountSats <= 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	return m.apply(pubkey, amountSats, "credit:"+ref)
+}
+
+func (m *memory) Debit(ctx context.Context, pubkey string, amountSats int64, ref string) (int64, error) {
+	if amountSats <= 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	return m.apply(pubkey, -amountSats, "debit:"+ref)
+}
+
+func (m *memory) apply(pubkey string, deltaSats int64, ref string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	balance := m.doc.Balances[pubkey]
+	if _, done := m.doc.Refs[ref]; done {
+		return balance, nil
+	}
+
+	if balance+deltaSats < 0 {
+		return balance, ErrInsufficientBalance
+	}
+
+	m.doc.Balances[pubkey] = balance + deltaSats
+	m.doc.Refs[ref] = struct{}{}
+
+	if m.path != "" {
+		if err := filestore.Save(m.path, m.doc); err != nil {
+			m.doc.Balances[pubkey] = balance
+			delete(m.doc.Refs, ref)
+			return balance, err
+		}
+	}
+
+	return balance + deltaSats, nil
+}
+
+func newDocument() *document {
+	return &document{
+		Balances: make(map[string]int64),
+		Refs:     make(map[string]struct{}),
+	}
+}
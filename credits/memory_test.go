@@ -0,0 +1,84 @@
+package credits
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	type op struct {
+		debit   bool
+		amount  int64
+		ref     string
+		want    int64
+		wantErr error
+	}
+	ops := []op{
+		{amount: 100, ref: "top-up", want: 100},
+		{amount: 100, ref: "top-up", want: 100},
+		{debit: true, amount: 30, ref: "job1", want: 70},
+		{debit: true, amount: 30, ref: "job1", want: 70},
+		{debit: true, amount: 30, ref: "job2", want: 40},
+		{debit: true, amount: 50, ref: "job3", want: 40, wantErr: ErrInsufficientBalance},
+		{debit: true, amount: 40, ref: "job3", want: 0},
+		{amount: 0, ref: "zero", wantErr: ErrInvalidAmount},
+		{debit: true, amount: -5, ref: "negative", wantErr: ErrInvalidAmount},
+		// credit and debit refs don't collide.
+		{amount: 10, ref: "job1", want: 10},
+	}
+
+	for i, o := range ops {
+		var (
+			got int64
+			err error
+		)
+		if o.debit {
+			got, err = s.Debit(ctx, "alice", o.amount, o.ref)
+		} else {
+			got, err = s.Credit(ctx, "alice", o.amount, o.ref)
+		}
+		if !errors.Is(err, o.wantErr) {
+			t.Fatalf("op %d: got error %v, want %v", i, err, o.wantErr)
+		}
+		if err == nil && got != o.want {
+			t.Fatalf("op %d: got balance %d, want %d", i, got, o.want)
+		}
+	}
+
+	if balance, _ := s.Balance(ctx, "alice"); balance != 10 {
+		t.Errorf("got balance %d, want 10", balance)
+	}
+	if balance, _ := s.Balance(ctx, "bob"); balance != 0 {
+		t.Errorf("got balance %d for unknown pubkey, want 0", balance)
+	}
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "credits.json")
+
+	s, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Credit(ctx, "alice", 100, "top-up"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := reopened.Balance(ctx, "alice"); balance != 100 {
+		t.Errorf("got balance %d, want 100", balance)
+	}
+	// refs survive the restart too.
+	if balance, _ := reopened.Credit(ctx, "alice", 100, "top-up"); balance != 100 {
+		t.Errorf("replayed top-up credited again, balance %d", balance)
+	}
+}
@@ -0,0 +1,44 @@
+package godvm
+
+import (
+	"errors"
+	"testing"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+func TestCreditableZap(t *testing.T) {
+	z := newZapper(t)
+	zapperPubkey, _ := goNostr.GetPublicKey(z.zapper)
+
+	request := z.request("dvm", "21000")
+	requestToOther := z.request("other", "21000")
+	receipt := z.receipt(KindZapReceipt, "dvm", z.bolt11(21000, request), request)
+	receiptToOther := z.receipt(KindZapReceipt, "other", z.bolt11(21000, requestToOther), requestToOther)
+
+	tests := []struct {
+		name    string
+		receipt *goNostr.Event
+		zappers []string
+		wantErr bool
+	}{
+		{"zap to a dvm", receipt, []string{zapperPubkey}, false},
+		{"untrusted zapper", receipt, []string{"other"}, true},
+		{"zap to someone else", receiptToOther, []string{zapperPubkey}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zap, err := creditableZap(tt.receipt, tt.zappers, []string{"dvm"})
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidZapReceipt) {
+					t.Fatalf("got error %v, want %v", err, ErrInvalidZapReceipt)
+				}
+				return
+			}
+			if err != nil || zap.RecipientPubkey != "dvm" {
+				t.Fatalf("got zap %+v and error %v", zap, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Package dedup remembers the events already handled, so the copies of an event delivered by several relays, or
+// delivered again after a reconnection or a restart, are handled once.
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/sebdeveloper6952/godvm/internal/filestore"
+)
+
+const (
+	DefaultCapacity = 100_000
+	DefaultTTL      = 24 * time.Hour
+
+	// saveInterval is the minimum time between two saves of a file backed cache.
+	saveInterval = 5 * time.Second
+)
+
+// save writes the keys to a file, replaced in tests.
+var save = filestore.Save
+
+// Stats counts the duplicates dropped by a cache.
+type Stats struct {
+	// Size is the number of keys remembered.
+	Size int
+
+	// Duplicates is the number of duplicates dropped per relay URL.
+	Duplicates map[string]int64
+}
+
+// Cache remembers up to a number of keys, for up to a time to live. When full, the least recently seen key is
+// forgotten first. It's safe for concurrent use.
+type Cache struct {
+	mu         sync.Mutex
+	capacity   int
+	ttl        time.Duration
+	order      *list.List
+	entries    map[string]*list.Element
+	duplicates map[string]int64
+
+	path    string
+	dirty   bool
+	saving  bool
+	savedAt time.Time
+	saveErr error
+
+	// saveMu serializes the saves, so an older snapshot never overwrites a newer one.
+	saveMu sync.Mutex
+}
+
+type entry struct {
+	Key    string    `json:"key"`
+	SeenAt time.Time `json:"seen_at"`
+}
+
+// NewMemory returns a cache that only lives in memory. A capacity or ttl of zero means DefaultCapacity or
+// DefaultTTL.
+func NewMemory(capacity int, ttl time.Duration) *Cache {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Cache{
+		capacity:   capacity,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		duplicates: make(map[string]int64),
+	}
+}
+
+// NewFile returns a cache kept in memory and saved as JSON to path, in the background at most every few seconds,
+// and on Flush. Keys in path that didn't expire are loaded first.
+func NewFile(path string, capacity int, ttl time.Duration) (*Cache, error) {
+	c := NewMemory(capacity, ttl)
+	c.path = path
+
+	saved := make([]entry, 0)
+	if err := filestore.Load(path, &saved); err != nil {
+		return nil, err
+	}
+
+	// saved is ordered from the most to the least recently seen.
+	now := time.Now()
+	for i := len(saved) - 1; i >= 0; i-- {
+		if now.Sub(saved[i].SeenAt) >= c.ttl {
+			continue
+		}
+		c.add(saved[i])
+	}
+	c.savedAt = now
+
+	return c, nil
+}
+
+// Seen remembers the key and reports whether it was already remembered, in which case the duplicate is counted
+// for relayURL.
+func (c *Cache) Seen(key string, relayURL string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	if el, ok := c.entries[key]; ok {
+		if now.Sub(el.Value.(*entry).SeenAt) < c.ttl {
+			c.order.MoveToFront(el)
+			c.duplicates[relayURL]++
+			return true
+		}
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+
+	c.add(entry{Key: key, SeenAt: now})
+	c.dirty = true
+	if c.path != "" && !c.saving && now.Sub(c.savedAt) >= saveInterval {
+		c.saving = true
+		go func() {
+			// the error is kept, and returned by Flush if the next save fails too.
+			_ = c.persist()
+
+			c.mu.Lock()
+			c.saving = false
+			c.mu.Unlock()
+		}()
+	}
+
+	return false
+}
+
+// Stats returns the number of keys remembered and of duplicates dropped.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	duplicates := make(map[string]int64, len(c.duplicates))
+	for url, n := range c.duplicates {
+		duplicates[url] = n
+	}
+
+	return Stats{
+		Size:       c.order.Len(),
+		Duplicates: duplicates,
+	}
+}
+
+// Flush saves the keys of a file backed cache that changed since the last save, once the save running in the
+// background, if any, is done. It returns the error of the last failed save, if it still failed.
+func (c *Cache) Flush() error {
+	if c.path == "" {
+		return nil
+	}
+
+	return c.persist()
+}
+
+// add must be called with c.mu held.
+func (c *Cache) add(e entry) {
+	c.entries[e.Key] = c.order.PushFront(&e)
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).Key)
+	}
+}
+
+// persist saves the keys if they changed or the last save failed. The keys are copied with c.mu held and written
+// without it, so Seen isn't blocked by the write. Expired keys are dropped.
+func (c *Cache) persist() error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.Lock()
+	if !c.dirty && c.saveErr == nil {
+		c.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	saved := make([]entry, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+		if now.Sub(e.SeenAt) < c.ttl {
+			saved = append(saved, *e)
+		}
+	}
+	c.dirty = false
+	c.savedAt = now
+	c.mu.Unlock()
+
+	err := save(c.path, saved)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.saveErr = err
+	if err != nil {
+		c.dirty = true
+	}
+
+	return err
+}
@@ -0,0 +1,138 @@
+package dedup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sebdeveloper6952/godvm/internal/filestore"
+)
+
+func TestSeen(t *testing.T) {
+	c := NewMemory(2, time.Hour)
+
+	steps := []struct {
+		key   string
+		relay string
+		want  bool
+	}{
+		{"a", "r1", false},
+		{"a", "r2", true},
+		{"b", "r1", false},
+		// seeing a again makes b the least recently seen key.
+		{"a", "r1", true},
+		{"c", "r1", false},
+		{"b", "r1", false},
+		{"a", "r1", false},
+	}
+
+	for i, s := range steps {
+		if got := c.Seen(s.key, s.relay); got != s.want {
+			t.Fatalf("step %d: Seen(%q) = %v, want %v", i, s.key, got, s.want)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Size != 2 {
+		t.Errorf("got size %d, want 2", stats.Size)
+	}
+	if stats.Duplicates["r1"] != 1 || stats.Duplicates["r2"] != 1 {
+		t.Errorf("unexpected duplicates %v", stats.Duplicates)
+	}
+}
+
+func TestSeenExpires(t *testing.T) {
+	c := NewMemory(0, 10*time.Millisecond)
+
+	if c.Seen("a", "r") {
+		t.Fatal("new key seen")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if c.Seen("a", "r") {
+		t.Fatal("expired key still seen")
+	}
+	if !c.Seen("a", "r") {
+		t.Fatal("key not seen again after expiring")
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.json")
+
+	c, err := NewFile(path, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Seen("a", "r")
+	c.Seen("b", "r")
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFile(path, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the most recently seen key fits.
+	if !reopened.Seen("b", "r") {
+		t.Error("b forgotten after reopening")
+	}
+	if reopened.Seen("a", "r") {
+		t.Error("a kept over capacity after reopening")
+	}
+}
+
+func TestSeenSavesInTheBackground(t *testing.T) {
+	saving := make(chan struct{})
+	release := make(chan struct{})
+	save = func(path string, v interface{}) error {
+		saving <- struct{}{}
+		<-release
+		return filestore.Save(path, v)
+	}
+	t.Cleanup(func() { save = filestore.Save })
+
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	c, err := NewFile(path, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.savedAt = time.Now().Add(-saveInterval)
+
+	c.Seen("a", "r")
+	<-saving
+
+	// the save is blocked, Seen must not be.
+	seen := make(chan struct{})
+	go func() {
+		c.Seen("b", "r")
+		close(seen)
+	}()
+	select {
+	case <-seen:
+	case <-time.After(time.Second):
+		t.Fatal("Seen blocked by the save")
+	}
+
+	// Flush saves b once the background save of a is done.
+	flushed := make(chan error)
+	go func() {
+		flushed <- c.Flush()
+	}()
+	release <- struct{}{}
+	<-saving
+	release <- struct{}{}
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFile(path, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if !reopened.Seen(key, "r") {
+			t.Errorf("%s not saved", key)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package godvm
 
 import (
 	"context"
+	"time"
 
 	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/sebdeveloper6952/godvm/lightning"
 )
 
 type Dvmer interface {
@@ -35,3 +37,39 @@ type Dvmer interface {
 		chanToEngine chan<- *JobUpdate,
 	) bool
 }
+
+// DvmOption configures how the engine handles a single DVM, see Engine.RegisterDVM.
+type DvmOption func(c *dvmConfig)
+
+type dvmConfig struct {
+	lnSvc       lightning.Service
+	unlockMode  UnlockMode
+	offer       bool
+	minPow      int
+	lowPowDelay time.Duration
+}
+
+// WithLnService makes the engine issue the invoices of this DVM, and pay its refunds, with ln instead of the engine
+// lightning service. Use it when DVMs hosted by the same engine are paid into different wallets.
+func WithLnService(ln lightning.Service) DvmOption {
+	return func(c *dvmConfig) {
+		c.lnSvc = ln
+	}
+}
+
+// WithUnlockMode makes results sent with StatusSuccessWithPayment unreadable until their invoice is paid, see
+// UnlockMode.
+func WithUnlockMode(mode UnlockMode) DvmOption {
+	return func(c *dvmConfig) {
+		c.unlockMode = mode
+	}
+}
+
+// WithOffer makes the engine create a BOLT12 offer for this DVM when its lightning service supports offers, see
+// lightning.Offerer. The offer is advertised in the NIP-89 event and sent along with every invoice, in a
+// ["bolt12", "<offer>"] tag. Customers paying with the offer must put the job request ID in the payer note.
+func WithOffer() DvmOption {
+	return func(c *dvmConfig) {
+		c.offer = true
+	}
+}
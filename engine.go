@@ -6,15 +6,46 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/sebdeveloper6952/godvm/dedup"
+	"github.com/sebdeveloper6952/godvm/fiat"
+	"github.com/sebdeveloper6952/godvm/invoices"
+	"github.com/sebdeveloper6952/godvm/ledger"
 	"github.com/sebdeveloper6952/godvm/lightning"
 )
 
+var (
+	ErrNoLnService    = errors.New("no lightning service for DVM")
+	ErrNoRateProvider = errors.New("no exchange rate provider to convert fiat amounts")
+)
+
 type Engine struct {
 	dvmsByKind      map[int][]Dvmer
+	dvmConfigs      map[string]*dvmConfig
 	nostrSvc        NostrService
 	lnSvc           lightning.Service
+	ledger          ledger.Ledger
+	refunds         *RefundConfig
+	credits         *CreditsConfig
+	rates           fiat.RateProvider
+	watcher         *invoiceWatcher
+	offersMu        sync.Mutex
+	offers          map[string]*lightning.Offer
+	offerWaits      map[string]*offerWait
+	pendingInvoices invoices.Store
+	relayLists      *relayListCache
+	identity        Signer
+	dedup           *dedup.Cache
+	startedAt       time.Time
+	publishRetries  *PublishRetryConfig
+	offloader       ContentOffloader
+	pow             *PowConfig
+	powSlots        chan struct{}
+	powMu           sync.Mutex
+	powQueues       map[powQueueKey][]func()
+	requestLimits   RequestLimits
 	log             *log.Logger
 	waitingForEvent map[string][]chan *goNostr.Event
 }
@@ -29,17 +60,37 @@ func NewEngine() (*Engine, error) {
 		return nil, err
 	}
 
+	dedupCache := dedup.NewMemory(0, 0)
+	nostrSvc.SetDedup(dedupCache)
+
 	e := &Engine{
 		dvmsByKind:      make(map[int][]Dvmer),
+		dvmConfigs:      make(map[string]*dvmConfig),
 		waitingForEvent: make(map[string][]chan *goNostr.Event),
 		nostrSvc:        nostrSvc,
+		watcher:         newInvoiceWatcher(logger),
+		offers:          make(map[string]*lightning.Offer),
+		offerWaits:      make(map[string]*offerWait),
+		relayLists:      newRelayListCache(),
+		dedup:           dedupCache,
+		requestLimits:   RequestLimits{}.withDefaults(),
 		log:             logger,
 	}
+	nostrSvc.SetAuthenticator(e.authSigner)
 
 	return e, nil
 }
 
-func (e *Engine) RegisterDVM(dvm Dvmer) {
+func (e *Engine) RegisterDVM(dvm Dvmer, opts ...DvmOption) {
+	cfg := &dvmConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	e.dvmConfigs[dvm.PublicKeyHex()] = cfg
+	if e.identity == nil {
+		e.identity = dvm
+	}
+
 	kindSupported := dvm.KindSupported()
 	if _, ok := e.dvmsByKind[kindSupported]; !ok {
 		e.dvmsByKind[kindSupported] = make([]Dvmer, 0, 2)
@@ -47,10 +98,79 @@ func (e *Engine) RegisterDVM(dvm Dvmer) {
 	e.dvmsByKind[kindSupported] = append(e.dvmsByKind[kindSupported], dvm)
 }
 
+// SetLnService sets the lightning service used by every DVM that wasn't registered with its own, see WithLnService.
 func (e *Engine) SetLnService(ln lightning.Service) {
 	e.lnSvc = ln
 }
 
+// lnServiceFor returns the lightning service of the DVM, falling back to the engine one.
+func (e *Engine) lnServiceFor(dvm Dvmer) (lightning.Service, error) {
+	if cfg, ok := e.dvmConfigs[dvm.PublicKeyHex()]; ok && cfg.lnSvc != nil {
+		return cfg.lnSvc, nil
+	}
+
+	if e.lnSvc == nil {
+		return nil, ErrNoLnService
+	}
+
+	return e.lnSvc, nil
+}
+
+// Relays returns the connection state of every relay the engine listens on.
+func (e *Engine) Relays() []RelayStatus {
+	return e.nostrSvc.Relays()
+}
+
+// AddRelay starts listening for job requests on the relay and publishes the DVMs NIP-89 and profile events to it.
+// The engine must be running. If the first connection attempt fails its error is returned and nothing is published,
+// but the relay is kept and retried like any other relay.
+func (e *Engine) AddRelay(ctx context.Context, url string) error {
+	if err := e.nostrSvc.AddRelay(ctx, url); err != nil {
+		return err
+	}
+
+	e.advertiseDvms(ctx, url)
+
+	return nil
+}
+
+// RemoveRelay stops listening on the relay, closes its connection and publishes the DVMs relay list without it.
+func (e *Engine) RemoveRelay(ctx context.Context, url string) error {
+	if err := e.nostrSvc.RemoveRelay(url); err != nil {
+		return err
+	}
+
+	for _, dvms := range e.dvmsByKind {
+		for i := range dvms {
+			e.advertiseRelayList(ctx, dvms[i])
+		}
+	}
+
+	return nil
+}
+
+// SetLedger makes the engine record every job it runs, with the invoices issued for it and their payments.
+func (e *Engine) SetLedger(l ledger.Ledger) {
+	e.ledger = l
+}
+
+// SetRateProvider sets the exchange rates used to convert fiat prices, see JobUpdate.FiatAmount.
+func (e *Engine) SetRateProvider(p fiat.RateProvider) {
+	e.rates = p
+}
+
+// SetDedup replaces the cache that drops the copies of job requests and zap receipts delivered by several relays.
+// Use dedup.NewFile so events already handled are not handled again after a restart.
+func (e *Engine) SetDedup(c *dedup.Cache) {
+	e.dedup = c
+	e.nostrSvc.SetDedup(c)
+}
+
+// DedupStats returns the number of duplicate events dropped per relay.
+func (e *Engine) DedupStats() dedup.Stats {
+	return e.dedup.Stats()
+}
+
 func (e *Engine) Run(
 	ctx context.Context,
 	initialRelays []string,
@@ -60,13 +180,26 @@ func (e *Engine) Run(
 	}
 
 	kindsSupported := e.getKindsSupported()
+	e.startedAt = time.Now()
 
 	go func() {
 		if err := e.nostrSvc.Run(ctx, kindsSupported, initialRelays); err != nil {
 			e.log.Printf("run nostr service %+v", err)
 		}
 
+		e.createOffers(ctx)
 		e.advertiseDvms(ctx)
+		e.listenForZaps(ctx)
+	}()
+	go e.retryPublishes(ctx)
+
+	e.resumePendingInvoices(ctx)
+	go func() {
+		<-ctx.Done()
+		e.watcher.stop()
+		if err := e.dedup.Flush(); err != nil {
+			e.log.Printf("save dedup cache %+v", err)
+		}
 	}()
 
 	go func() {
@@ -76,49 +209,10 @@ func (e *Engine) Run(
 				dvmsForKind, ok := e.dvmsByKind[event.Kind]
 				if !ok {
 					e.log.Printf("no dvms for kind %d\n", event.Kind)
+					e.nostrSvc.JobRequestHandled(event)
 					continue
 				}
-
-				nip90Input, err := Nip90InputFromJobRequestEvent(event)
-				if err != nil {
-					e.log.Printf("nip90Input from event  %+v\n", err)
-					continue
-				}
-
-				// if the inputs are asking for events/jobs, we fetch them here before proceeding
-				var wg sync.WaitGroup
-				for inputIdx := range nip90Input.Inputs {
-					if nip90Input.Inputs[inputIdx].Type == InputTypeEvent ||
-						nip90Input.Inputs[inputIdx].Type == InputTypeJob {
-						wg.Add(1)
-						go func(input *Input) {
-							defer wg.Done()
-
-							// TODO: must handle when the event is not found, only when the input type is "event".
-							//       When input type is "job", we have to wait no matter what, because it could
-							//       be a job that is completed in the future.
-							waitCh, err := e.nostrSvc.FetchEvent(ctx, input.Value)
-							if err != nil {
-								e.log.Printf("fetch event for job input %+v", err)
-								return
-							}
-							input.Event = <-waitCh
-
-							e.log.Printf("fetched event for job input")
-						}(nip90Input.Inputs[inputIdx])
-					}
-				}
-				wg.Wait()
-
-				e.log.Printf("finished waiting for input events")
-
-				for i := range dvmsForKind {
-					go func(dvm Dvmer, input *Nip90Input) {
-						if err := e.runDvm(ctx, dvm, input); err != nil {
-							e.log.Println(err)
-						}
-					}(dvmsForKind[i], nip90Input)
-				}
+				go e.serveJobRequest(ctx, event, dvmsForKind)
 			case <-ctx.Done():
 				return
 			}
@@ -128,29 +222,132 @@ func (e *Engine) Run(
 	return nil
 }
 
+// serveJobRequest resolves the inputs of the job request and runs it on every DVM of its kind that accepts its
+// proof of work. It returns once every DVM is done with it, and then lets the relay checkpoints move past it.
+func (e *Engine) serveJobRequest(ctx context.Context, event *goNostr.Event, dvms []Dvmer) {
+	// requests interrupted by the engine stopping are not handled, they are caught up on at the next run.
+	defer func() {
+		if ctx.Err() == nil {
+			e.nostrSvc.JobRequestHandled(event)
+		}
+	}()
+
+	accepted := make([]Dvmer, 0, len(dvms))
+	delays := make(map[Dvmer]time.Duration, len(dvms))
+	for i := range dvms {
+		delay, ok := e.powDelay(dvms[i], event)
+		if !ok {
+			e.log.Printf("job request %s below the proof of work of %s", event.ID, dvms[i].PublicKeyHex())
+			continue
+		}
+		accepted = append(accepted, dvms[i])
+		delays[dvms[i]] = delay
+	}
+	if len(accepted) == 0 || e.skipJobRequest(ctx, event, accepted) {
+		return
+	}
+
+	if err := ValidateJobRequest(event, e.requestLimits); err != nil {
+		e.rejectJobRequest(ctx, accepted, event, err)
+		return
+	}
+
+	nip90Input, err := Nip90InputFromJobRequestEvent(event)
+	if err != nil {
+		e.rejectJobRequest(ctx, accepted, event, err)
+		return
+	}
+
+	if err := e.resolveInputs(ctx, nip90Input); err != nil {
+		e.rejectJobRequest(ctx, accepted, event, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := range accepted {
+		wg.Add(1)
+		go func(dvm Dvmer, delay time.Duration) {
+			defer wg.Done()
+
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err := e.runDvm(ctx, dvm, nip90Input); err != nil {
+				e.log.Println(err)
+			}
+		}(accepted[i], delays[accepted[i]])
+	}
+	wg.Wait()
+}
+
 func (e *Engine) runDvm(ctx context.Context, dvm Dvmer, input *Nip90Input) error {
-	chanToDvm := make(chan *JobUpdate)
+	run := newJobRun(dvm, input)
 	chanToEngine := make(chan *JobUpdate)
 
-	defer func() {
-		close(chanToDvm)
-	}()
+	defer run.finish()
 
-	if !dvm.Run(ctx, input, chanToDvm, chanToEngine) {
+	if !dvm.Run(ctx, input, run.chanToDvm, chanToEngine) {
 		return errors.New("job not accepted by DVM")
 	}
 
+	e.recordJob(ctx, dvm, input, func(entry *ledger.Entry) {
+		entry.Status = "accepted"
+	})
+
 	for {
 		select {
 		case update := <-chanToEngine:
+			if update.FiatAmount > 0 &&
+				(update.Status == StatusPaymentRequired || update.Status == StatusSuccessWithPayment) {
+				if err := e.convertFiatAmount(ctx, run, update); err != nil {
+					run.notify(&JobUpdate{
+						Status: StatusError,
+					})
+					return err
+				}
+			}
+
+			if update.Status == StatusPaymentRequired {
+				if balance, paid := e.payWithCredits(ctx, run, int64(update.AmountSats)); paid {
+					if err := e.sendFeedbackEvent(ctx, dvm, input, &JobUpdate{
+						Status:    StatusProcessing,
+						ExtraTags: [][]string{balanceTag(balance)},
+					}); err != nil {
+						return err
+					}
+					run.notify(&JobUpdate{
+						Status: StatusPaymentCompleted,
+					})
+					continue
+				}
+			}
+
+			var paid <-chan bool
 			if update.Status == StatusPaymentRequired || update.Status == StatusSuccessWithPayment {
-				invoice, err := e.addInvoiceAndTrack(ctx, chanToDvm, int64(update.AmountSats))
+				invoice, invoicePaid, err := e.addInvoiceAndTrack(
+					ctx,
+					run,
+					int64(update.AmountSats),
+					update.Status == StatusSuccessWithPayment,
+				)
 				if err != nil {
 					return err
 				}
 				update.PaymentRequest = invoice.PayReq
+				paid = invoicePaid
+				if offer := e.offerFor(dvm); offer != nil {
+					update.ExtraTags = append(update.ExtraTags, []string{"bolt12", offer.Bolt12})
+				}
 			}
 
+			e.recordJob(ctx, dvm, input, func(entry *ledger.Entry) {
+				entry.Status = JobStatusToString[update.Status]
+			})
+
 			if err := e.sendFeedbackEvent(
 				ctx,
 				dvm,
@@ -160,6 +357,18 @@ func (e *Engine) runDvm(ctx context.Context, dvm Dvmer, input *Nip90Input) error
 				return err
 			}
 
+			if update.Status == StatusError {
+				if e.refunds != nil && run.paidSats.Load() > 0 {
+					e.refund(ctx, run, update)
+				}
+
+				return nil
+			}
+
+			if update.Status == StatusSuccessWithPayment && e.unlockModeFor(dvm) != UnlockModeNone {
+				return e.sendLockedJobResult(ctx, run, update, paid)
+			}
+
 			if update.Status == StatusSuccess || update.Status == StatusSuccessWithPayment {
 				if err := e.sendJobResultEvent(
 					ctx,
@@ -180,10 +389,14 @@ func (e *Engine) runDvm(ctx context.Context, dvm Dvmer, input *Nip90Input) error
 	}
 }
 
-// advertiseDvms publishes two events:
+// advertiseDvms publishes three events:
 // - kind 31990 for nip-89 handler information
 // - kind 0 for nip-01 profile metadata
-func (e *Engine) advertiseDvms(ctx context.Context) {
+// - kind 10002 for the nip-65 relay list
+//
+// The handler information and profile are published to every relay, or only to the given relays. The relay list
+// changes with the relay set, so it's always published to every relay.
+func (e *Engine) advertiseDvms(ctx context.Context, relays ...string) {
 	for kind, dvms := range e.dvmsByKind {
 		for i := range dvms {
 			ev := NewHandlerInformationEvent(
@@ -192,8 +405,14 @@ func (e *Engine) advertiseDvms(ctx context.Context) {
 				[]int{kind},
 				dvms[i].Version(),
 			)
-			dvms[i].Sign(ev)
-			if err := e.nostrSvc.PublishEvent(ctx, *ev); err != nil {
+			if offer := e.offerFor(dvms[i]); offer != nil {
+				ev.Tags = append(ev.Tags, goNostr.Tag{"bolt12", offer.Bolt12})
+			}
+			if err := e.signEvent(ctx, dvms[i], ev); err != nil {
+				e.log.Printf("sign nip-89 %s %+v", dvms[i].PublicKeyHex(), err)
+				continue
+			}
+			if err := e.publishAdvertisement(ctx, ev, relays); err != nil {
 				e.log.Printf("publish nip-89 %s %+v", dvms[i].PublicKeyHex(), err)
 			}
 
@@ -201,49 +420,132 @@ func (e *Engine) advertiseDvms(ctx context.Context) {
 				dvms[i].PublicKeyHex(),
 				dvms[i].Profile(),
 			)
-			dvms[i].Sign(profileEv)
-			if err := e.nostrSvc.PublishEvent(ctx, *profileEv); err != nil {
+			if err := dvms[i].Sign(profileEv); err != nil {
+				e.log.Printf("sign profile %s %+v", dvms[i].PublicKeyHex(), err)
+				continue
+			}
+			if err := e.publishAdvertisement(ctx, profileEv, relays); err != nil {
 				e.log.Printf("publish profile %s %+v", dvms[i].PublicKeyHex(), err)
 			}
+
+			e.advertiseRelayList(ctx, dvms[i])
 		}
 	}
 }
 
+// addInvoiceAndTrack issues an invoice for the job and watches it. The returned channel receives true once the
+// invoice settles, or the job is paid through the offer of the DVM, or false if the invoice can no longer be paid.
+// The invoice stops being watched when the job finishes, unless outlivesJob is set, e.g. for results delivered
+// before payment.
 func (e *Engine) addInvoiceAndTrack(
 	ctx context.Context,
-	chanToDvm chan<- *JobUpdate,
+	run *jobRun,
 	amountSats int64,
-) (*lightning.Invoice, error) {
-	invoice, err := e.lnSvc.AddInvoice(ctx, amountSats)
+	outlivesJob bool,
+) (*lightning.Invoice, <-chan bool, error) {
+	lnSvc, err := e.lnServiceFor(run.dvm)
 	if err != nil {
-		chanToDvm <- &JobUpdate{
+		run.notify(&JobUpdate{
 			Status: StatusError,
-		}
-		return nil, err
+		})
+		return nil, nil, err
 	}
 
-	go func() {
-		u, e := e.lnSvc.TrackInvoice(ctx, invoice)
-	trackInvoiceLoop:
-		for {
-			select {
-			case invoiceUpdate := <-u:
-				if invoiceUpdate.Settled {
-					chanToDvm <- &JobUpdate{
-						Status: StatusPaymentCompleted,
-					}
-					break trackInvoiceLoop
-				}
-			case <-e:
-				chanToDvm <- &JobUpdate{
+	invoice, err := lnSvc.AddInvoice(ctx, amountSats)
+	if err != nil {
+		run.notify(&JobUpdate{
+			Status: StatusError,
+		})
+		return nil, nil, err
+	}
+
+	e.recordJob(ctx, run.dvm, run.input, func(entry *ledger.Entry) {
+		entry.InvoiceHash = invoice.Hash.String()
+		entry.AmountRequestedSats += amountSats
+		entry.InvoicedAt = time.Now()
+	})
+
+	pending := &invoices.Pending{
+		Hash:           invoice.Hash.String(),
+		PayReq:         invoice.PayReq,
+		Purpose:        invoices.PurposeJob,
+		JobID:          run.input.JobRequestId,
+		DvmPubkey:      run.dvm.PublicKeyHex(),
+		CustomerPubkey: run.input.CustomerPubkey,
+		AmountSats:     amountSats,
+		CreatedAt:      time.Now(),
+	}
+	e.savePendingInvoice(ctx, pending)
+
+	paid := make(chan bool, 1)
+
+	var once sync.Once
+	resolve := func(err error) {
+		once.Do(func() {
+			e.deletePendingInvoice(pending.Hash)
+			e.cancelOfferWait(run)
+
+			if err != nil {
+				e.log.Printf("invoice %s of job %s not paid %+v", pending.Hash, pending.JobID, err)
+				paid <- false
+				run.notify(&JobUpdate{
 					Status: StatusError,
-				}
+				})
 				return
 			}
-		}
-	}()
 
-	return invoice, nil
+			run.paidSats.Add(amountSats)
+			e.recordJob(context.Background(), run.dvm, run.input, func(entry *ledger.Entry) {
+				entry.AmountPaidSats += amountSats
+				entry.PaidAt = time.Now()
+			})
+			paid <- true
+			run.notify(&JobUpdate{
+				Status: StatusPaymentCompleted,
+			})
+		})
+	}
+
+	unwatch := e.watcher.watch(lnSvc, invoice, resolve)
+	e.waitForOfferPayment(run, amountSats, func() {
+		unwatch()
+		resolve(nil)
+	})
+
+	if !outlivesJob {
+		run.onFinish(func() {
+			unwatch()
+			e.cancelOfferWait(run)
+			e.deletePendingInvoice(pending.Hash)
+		})
+	}
+
+	return invoice, paid, nil
+}
+
+// advertiseRelayList publishes the relays of the engine as the NIP-65 relay list of the DVM, so customers know where
+// to send job requests and read results.
+func (e *Engine) advertiseRelayList(ctx context.Context, dvm Dvmer) {
+	ev := NewRelayListEvent(dvm.PublicKeyHex(), e.relayURLs())
+	if err := dvm.Sign(ev); err != nil {
+		e.log.Printf("sign relay list %s %+v", dvm.PublicKeyHex(), err)
+		return
+	}
+
+	if err := e.publishAdvertisement(ctx, ev, nil); err != nil {
+		e.log.Printf("publish relay list %s %+v", dvm.PublicKeyHex(), err)
+	}
+}
+
+func (e *Engine) publishAdvertisement(ctx context.Context, ev *goNostr.Event, relays []string) error {
+	var err error
+	if len(relays) > 0 {
+		_, err = e.nostrSvc.PublishEventTo(ctx, *ev, relays...)
+	} else {
+		_, err = e.nostrSvc.PublishEvent(ctx, *ev)
+	}
+
+	return err
 }
 
 func (e *Engine) sendFeedbackEvent(
@@ -253,15 +555,12 @@ func (e *Engine) sendFeedbackEvent(
 	update *JobUpdate,
 ) error {
 	feedbackEvent := Nip90JobFeedbackFromEngineUpdate(input, update)
-	if err := dvm.Sign(feedbackEvent); err != nil {
-		return err
-	}
+	relays := e.customerRelays(ctx, input)
 
-	return e.nostrSvc.PublishEvent(
-		ctx,
-		*feedbackEvent,
-		input.Relays...,
-	)
+	return e.signAndPublish(ctx, dvm, input, feedbackEvent, func() error {
+		_, err := e.nostrSvc.PublishEvent(ctx, *feedbackEvent, relays...)
+		return err
+	})
 }
 
 func (e *Engine) sendJobResultEvent(
@@ -271,15 +570,58 @@ func (e *Engine) sendJobResultEvent(
 	update *JobUpdate,
 ) error {
 	jobResultEvent := Nip90JobResultFromEngineUpdate(input, update)
-	if err := dvm.Sign(jobResultEvent); err != nil {
+	relays := e.customerRelays(ctx, input)
+	e.offloadJobResult(ctx, jobResultEvent, relays)
+
+	return e.signAndPublish(ctx, dvm, input, jobResultEvent, func() error {
+		_, err := e.nostrSvc.PublishEvent(ctx, *jobResultEvent, relays...)
+		if errors.Is(err, ErrPublishQuorum) && e.publishRetries != nil {
+			return e.enqueuePublish(ctx, jobResultEvent, relays, err)
+		}
+
 		return err
+	})
+}
+
+// convertFiatAmount sets the sats amount of the update from its fiat amount, and records both on the job.
+func (e *Engine) convertFiatAmount(ctx context.Context, run *jobRun, update *JobUpdate) error {
+	if e.rates == nil {
+		return ErrNoRateProvider
 	}
 
-	return e.nostrSvc.PublishEvent(
-		ctx,
-		*jobResultEvent,
-		input.Relays...,
-	)
+	amountSats, err := fiat.ToSats(ctx, e.rates, update.FiatAmount, update.FiatCurrency)
+	if err != nil {
+		return err
+	}
+	update.AmountSats = int(amountSats)
+
+	e.recordJob(ctx, run.dvm, run.input, func(entry *ledger.Entry) {
+		entry.FiatAmount += update.FiatAmount
+		entry.FiatCurrency = update.FiatCurrency
+	})
+
+	return nil
+}
+
+// recordJob applies fn to the ledger entry of the job run by the given DVM. Ledger failures are only logged, they
+// never stop a job.
+func (e *Engine) recordJob(
+	ctx context.Context,
+	dvm Dvmer,
+	input *Nip90Input,
+	fn func(entry *ledger.Entry),
+) {
+	if e.ledger == nil {
+		return
+	}
+
+	if err := e.ledger.Update(ctx, input.JobRequestId, dvm.PublicKeyHex(), func(entry *ledger.Entry) {
+		entry.Kind = input.Event.Kind
+		entry.CustomerPubkey = input.CustomerPubkey
+		fn(entry)
+	}); err != nil {
+		e.log.Printf("ledger update job %s %+v", input.JobRequestId, err)
+	}
 }
 
 func (e *Engine) getKindsSupported() []int {
@@ -0,0 +1,183 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	ephemeralIdleTimeout = time.Minute
+	maxEphemeralConns    = 50
+)
+
+var (
+	ErrTooManyConnections = errors.New("too many relay connections")
+)
+
+// ephemeralPool shares the connections to relays that are not in the relay pool, e.g. the relays of a job request,
+// between publishing and fetching. Connections are closed once idle for ephemeralIdleTimeout, and at most
+// maxEphemeralConns are open at once.
+type ephemeralPool struct {
+	mu    sync.Mutex
+	conns map[string]*ephemeralConn
+}
+
+type ephemeralConn struct {
+	// ready is closed once the connection attempt finished, successfully or not.
+	ready chan struct{}
+	relay *goNostr.Relay
+	err   error
+
+	users    int
+	lastUsed time.Time
+}
+
+func newEphemeralPool() *ephemeralPool {
+	return &ephemeralPool{
+		conns: make(map[string]*ephemeralConn),
+	}
+}
+
+// start closes idle connections until ctx is done, and then every connection.
+func (p *ephemeralPool) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ephemeralIdleTimeout / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.closeIdle(time.Now().Add(-ephemeralIdleTimeout))
+			case <-ctx.Done():
+				p.closeIdle(time.Now())
+				return
+			}
+		}
+	}()
+}
+
+// acquire returns a connection to the relay, opening one if there is none. The connection must be given back with
+// the returned function once done.
+func (p *ephemeralPool) acquire(ctx context.Context, url string) (*goNostr.Relay, func(), error) {
+	url = goNostr.NormalizeURL(url)
+
+	for {
+		p.mu.Lock()
+		conn, ok := p.conns[url]
+		if !ok {
+			break
+		}
+
+		select {
+		case <-conn.ready:
+		default:
+			p.mu.Unlock()
+			select {
+			case <-conn.ready:
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+			continue
+		}
+
+		if conn.err == nil && conn.relay.IsConnected() {
+			conn.users++
+			p.mu.Unlock()
+			return conn.relay, p.releaseFunc(conn), nil
+		}
+
+		// the connection failed or dropped, it's replaced below.
+		if p.conns[url] == conn {
+			delete(p.conns, url)
+		}
+		p.mu.Unlock()
+	}
+
+	if len(p.conns) >= maxEphemeralConns && !p.evictIdle() {
+		p.mu.Unlock()
+		return nil, nil, ErrTooManyConnections
+	}
+
+	conn := &ephemeralConn{
+		ready: make(chan struct{}),
+		users: 1,
+	}
+	p.conns[url] = conn
+	p.mu.Unlock()
+
+	connectCtx, cancel := context.WithTimeout(ctx, relayConnectTimeout)
+	relay, err := goNostr.RelayConnect(connectCtx, url)
+	cancel()
+
+	p.mu.Lock()
+	conn.relay = relay
+	conn.err = err
+	close(conn.ready)
+	if err != nil && p.conns[url] == conn {
+		delete(p.conns, url)
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return relay, p.releaseFunc(conn), nil
+}
+
+func (p *ephemeralPool) releaseFunc(conn *ephemeralConn) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			conn.users--
+			conn.lastUsed = time.Now()
+		})
+	}
+}
+
+// evictIdle must be called with p.mu held. It closes the least recently used connection that is not in use, and
+// reports whether there was one.
+func (p *ephemeralPool) evictIdle() bool {
+	var (
+		oldestURL string
+		oldest    *ephemeralConn
+	)
+	for url, conn := range p.conns {
+		if conn.users > 0 || conn.relay == nil {
+			continue
+		}
+		if oldest == nil || conn.lastUsed.Before(oldest.lastUsed) {
+			oldestURL, oldest = url, conn
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+
+	oldest.relay.Close()
+	delete(p.conns, oldestURL)
+
+	return true
+}
+
+// closeIdle closes the connections not in use since before.
+func (p *ephemeralPool) closeIdle(before time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for url, conn := range p.conns {
+		if conn.users > 0 || conn.relay == nil || conn.lastUsed.After(before) {
+			continue
+		}
+		conn.relay.Close()
+		delete(p.conns, url)
+	}
+}
@@ -0,0 +1,413 @@
+package godvm
+
+import (
+	"container/list"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const (
+	fetchedEventsCacheSize = 1000
+	jobInputTimeout        = 10 * time.Minute
+	jobInputPollInterval   = 15 * time.Second
+)
+
+var (
+	ErrFetchTimeout          = errors.New("timed out fetching event")
+	ErrInvalidEventReference = errors.New("invalid event reference")
+	ErrInvalidCoordinate     = errors.New("invalid event coordinate")
+)
+
+// Coordinate is the address of a replaceable or addressable event, written kind:pubkey:d-tag as in `a` tags.
+type Coordinate struct {
+	Kind       int
+	Pubkey     string
+	Identifier string
+}
+
+// ParseCoordinate parses a kind:pubkey:d-tag coordinate. The identifier may be empty, as it is for replaceable
+// events, and may contain colons.
+func ParseCoordinate(value string) (*Coordinate, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || !isHexID(parts[1]) {
+		return nil, ErrInvalidCoordinate
+	}
+
+	kind, err := strconv.Atoi(parts[0])
+	if err != nil || !isReplaceableKind(kind) && !isAddressableKind(kind) {
+		return nil, ErrInvalidCoordinate
+	}
+
+	return &Coordinate{
+		Kind:       kind,
+		Pubkey:     parts[1],
+		Identifier: parts[2],
+	}, nil
+}
+
+func (c *Coordinate) String() string {
+	return fmt.Sprintf("%d:%s:%s", c.Kind, c.Pubkey, c.Identifier)
+}
+
+// Filter returns the filter that matches every version of the event.
+func (c *Coordinate) Filter() goNostr.Filter {
+	filter := goNostr.Filter{
+		Kinds:   []int{c.Kind},
+		Authors: []string{c.Pubkey},
+	}
+	if isAddressableKind(c.Kind) {
+		filter.Tags = goNostr.TagMap{"d": []string{c.Identifier}}
+	}
+
+	return filter
+}
+
+func isReplaceableKind(kind int) bool {
+	return kind == 0 || kind == 3 || kind >= 10000 && kind < 20000
+}
+
+func isAddressableKind(kind int) bool {
+	return kind >= 30000 && kind < 40000
+}
+
+// EventReference is what an input of type "event" or "job" points to: an event ID, or the coordinate of the
+// latest version of a replaceable or addressable event, along with the relays it's expected to be found on.
+type EventReference struct {
+	Filter goNostr.Filter
+	Relays []string
+
+	// ID is set when the reference points to a single event, e.g. a hex ID, note or nevent.
+	ID string
+
+	// Coordinate is set when the reference points to the latest version of an event, e.g. a coordinate or naddr.
+	Coordinate *Coordinate
+}
+
+// ParseEventReference parses a hex event ID, a kind:pubkey:d-tag coordinate or a NIP-19 note, nevent or naddr,
+// with or without the "nostr:" prefix.
+func ParseEventReference(value string) (*EventReference, error) {
+	value = strings.TrimPrefix(value, "nostr:")
+
+	if isHexID(value) {
+		return eventIDReference(value, nil), nil
+	}
+	if strings.Contains(value, ":") {
+		coordinate, err := ParseCoordinate(value)
+		if err != nil {
+			return nil, err
+		}
+		return coordinateReference(coordinate, nil), nil
+	}
+
+	prefix, data, err := nip19.Decode(value)
+	if err != nil {
+		return nil, ErrInvalidEventReference
+	}
+
+	switch prefix {
+	case "note":
+		return eventIDReference(data.(string), nil), nil
+	case "nevent":
+		pointer := data.(goNostr.EventPointer)
+		ref := eventIDReference(pointer.ID, pointer.Relays)
+		if pointer.Author != "" {
+			ref.Filter.Authors = []string{pointer.Author}
+		}
+		return ref, nil
+	case "naddr":
+		pointer := data.(goNostr.EntityPointer)
+		if !isHexID(pointer.PublicKey) || !isReplaceableKind(pointer.Kind) && !isAddressableKind(pointer.Kind) {
+			return nil, ErrInvalidEventReference
+		}
+		return coordinateReference(&Coordinate{
+			Kind:       pointer.Kind,
+			Pubkey:     pointer.PublicKey,
+			Identifier: pointer.Identifier,
+		}, pointer.Relays), nil
+	}
+
+	return nil, ErrInvalidEventReference
+}
+
+func eventIDReference(id string, relays []string) *EventReference {
+	return &EventReference{
+		Filter: goNostr.Filter{IDs: []string{id}},
+		Relays: relays,
+		ID:     id,
+	}
+}
+
+func coordinateReference(coordinate *Coordinate, relays []string) *EventReference {
+	return &EventReference{
+		Filter:     coordinate.Filter(),
+		Relays:     relays,
+		Coordinate: coordinate,
+	}
+}
+
+func isHexID(value string) bool {
+	if len(value) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+
+	return err == nil
+}
+
+// validEvent reports whether the event matches the filter and its ID and signature are valid.
+func validEvent(filter goNostr.Filter, event *goNostr.Event) bool {
+	return filter.Matches(event) && authentic(event)
+}
+
+// query asks every connected relay of the pool and every other relay in relays for the events matching the filter,
+// until they all sent their stored events or ctx is done. Events with an invalid ID or signature are dropped. When
+// first is set, it returns as soon as a valid event is received. It returns ErrEventNotFound when every relay
+// answered without a match, and ErrFetchTimeout when some relay didn't answer in time.
+func (s *svc) query(
+	ctx context.Context,
+	filter goNostr.Filter,
+	first bool,
+	relays ...string,
+) ([]*goNostr.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		events   = make([]*goNostr.Event, 0, 1)
+		seen     = make(map[string]struct{})
+		timedOut bool
+	)
+
+	collect := func(relayURL string, relay *goNostr.Relay) {
+		found, answered := s.querySync(ctx, relay, filter)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !answered {
+			timedOut = true
+		}
+		for _, event := range found {
+			if _, ok := seen[event.ID]; ok {
+				continue
+			}
+			if !validEvent(filter, event) {
+				s.log.Printf("relay %s sent invalid event %s", relayURL, event.ID)
+				continue
+			}
+			seen[event.ID] = struct{}{}
+			events = append(events, event)
+			if first {
+				cancel()
+			}
+		}
+	}
+
+	pooled := s.pool.connected()
+	inPool := make(map[string]struct{}, len(pooled))
+	for i := range pooled {
+		inPool[pooled[i].URL] = struct{}{}
+
+		wg.Add(1)
+		go func(relay *goNostr.Relay) {
+			defer wg.Done()
+			collect(relay.URL, relay)
+		}(pooled[i])
+	}
+
+	for _, url := range relays {
+		url = goNostr.NormalizeURL(url)
+		if _, ok := inPool[url]; ok || url == "" {
+			continue
+		}
+		inPool[url] = struct{}{}
+
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+
+			if err := s.relayInfos.allowed(s.relayInfos.get(ctx, url)); err != nil {
+				s.log.Printf("fetch event from relay %s %+v", url, err)
+				return
+			}
+
+			relay, release, err := s.ephemeral.acquire(ctx, url)
+			if err != nil {
+				s.log.Printf("connect/fetch event from relay %s %+v", url, err)
+				mu.Lock()
+				timedOut = timedOut || ctx.Err() != nil
+				mu.Unlock()
+				return
+			}
+			defer release()
+
+			collect(url, relay)
+		}(url)
+	}
+
+	wg.Wait()
+
+	if len(events) > 0 {
+		return events, nil
+	}
+	if timedOut {
+		return nil, ErrFetchTimeout
+	}
+
+	return nil, ErrEventNotFound
+}
+
+// querySync returns the events the relay sent for the filter, and whether it sent all its stored events before ctx
+// was done.
+func (s *svc) querySync(
+	ctx context.Context,
+	relay *goNostr.Relay,
+	filter goNostr.Filter,
+) ([]*goNostr.Event, bool) {
+	sub, err := relay.Subscribe(ctx, goNostr.Filters{filter})
+	if err != nil {
+		s.log.Printf("query relay %s %+v", relay.URL, err)
+		return nil, true
+	}
+	defer sub.Unsub()
+
+	events := make([]*goNostr.Event, 0, 1)
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok || event == nil {
+				return events, true
+			}
+			events = append(events, event)
+		case <-sub.EndOfStoredEvents:
+			return events, true
+		case <-sub.ClosedReason:
+			return events, true
+		case <-ctx.Done():
+			return events, false
+		}
+	}
+}
+
+// eventCache keeps the last events fetched by ID. Events are immutable, so they never expire.
+type eventCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newEventCache() *eventCache {
+	return &eventCache{
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *eventCache) get(id string) (*goNostr.Event, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+
+	return el.Value.(*goNostr.Event), true
+}
+
+func (c *eventCache) add(event *goNostr.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[event.ID]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[event.ID] = c.order.PushFront(event)
+	for c.order.Len() > fetchedEventsCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*goNostr.Event).ID)
+	}
+}
+
+// resolveInputs fetches the events that the inputs of type "event" and "job" point to, in parallel, and sets them
+// on Input.Event.
+func (e *Engine) resolveInputs(ctx context.Context, request *Nip90Input) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(request.Inputs))
+	)
+
+	for i := range request.Inputs {
+		if request.Inputs[i].Type != InputTypeEvent && request.Inputs[i].Type != InputTypeJob {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = e.resolveInput(ctx, request, request.Inputs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// resolveInput looks for the event on the relay hint of the input, the relays of its reference and the relays of
+// the job request, besides our own. An input of type "job" may point to a job that isn't done yet, so it's fetched
+// again until jobInputTimeout.
+func (e *Engine) resolveInput(ctx context.Context, request *Nip90Input, input *Input) error {
+	ref, err := ParseEventReference(input.Value)
+	if err != nil {
+		return fmt.Errorf("input %s: %w", input.Value, err)
+	}
+
+	relays := make([]string, 0, 1+len(ref.Relays)+len(request.Relays))
+	if input.Relay != "" {
+		relays = append(relays, input.Relay)
+	}
+	relays = append(relays, ref.Relays...)
+	relays = append(relays, request.Relays...)
+
+	fetch := func() (*goNostr.Event, error) {
+		if ref.ID != "" {
+			return e.nostrSvc.FetchEvent(ctx, ref.ID, relays...)
+		}
+		return e.nostrSvc.FetchLatestEvent(ctx, ref.Filter, relays...)
+	}
+
+	event, err := fetch()
+	if input.Type == InputTypeJob {
+		deadline := time.Now().Add(jobInputTimeout)
+		for err != nil && time.Now().Before(deadline) {
+			select {
+			case <-time.After(jobInputPollInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			event, err = fetch()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("input %s: %w", input.Value, err)
+	}
+	input.Event = event
+	input.Coordinate = ref.Coordinate
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package godvm
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestParseEventReference(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	author := strings.Repeat("cd", 32)
+	relays := []string{"wss://relay.example.com"}
+
+	encode := func(encoded string, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    *EventReference
+		wantErr error
+	}{
+		{
+			name:  "hex id",
+			value: id,
+			want:  &EventReference{Filter: goNostr.Filter{IDs: []string{id}}, ID: id},
+		},
+		{
+			name:  "note",
+			value: "nostr:" + encode(nip19.EncodeNote(id)),
+			want:  &EventReference{Filter: goNostr.Filter{IDs: []string{id}}, ID: id},
+		},
+		{
+			name:  "nevent",
+			value: encode(nip19.EncodeEvent(id, relays, author)),
+			want: &EventReference{
+				Filter: goNostr.Filter{IDs: []string{id}, Authors: []string{author}},
+				Relays: relays,
+				ID:     id,
+			},
+		},
+		{
+			name:  "coordinate",
+			value: "30023:" + author + ":post",
+			want: &EventReference{
+				Filter: goNostr.Filter{
+					Kinds:   []int{30023},
+					Authors: []string{author},
+					Tags:    goNostr.TagMap{"d": []string{"post"}},
+				},
+				Coordinate: &Coordinate{Kind: 30023, Pubkey: author, Identifier: "post"},
+			},
+		},
+		{
+			name:  "naddr",
+			value: encode(nip19.EncodeEntity(author, 30023, "post", relays)),
+			want: &EventReference{
+				Filter: goNostr.Filter{
+					Kinds:   []int{30023},
+					Authors: []string{author},
+					Tags:    goNostr.TagMap{"d": []string{"post"}},
+				},
+				Relays:     relays,
+				Coordinate: &Coordinate{Kind: 30023, Pubkey: author, Identifier: "post"},
+			},
+		},
+		{
+			name:    "naddr of a regular kind",
+			value:   encode(nip19.EncodeEntity(author, 1, "post", relays)),
+			wantErr: ErrInvalidEventReference,
+		},
+		{
+			name:    "invalid coordinate",
+			value:   "1:" + author + ":",
+			wantErr: ErrInvalidCoordinate,
+		},
+		{
+			name:    "short hex id",
+			value:   id[:62],
+			wantErr: ErrInvalidEventReference,
+		},
+		{
+			name:    "npub",
+			value:   encode(nip19.EncodePublicKey(author)),
+			wantErr: ErrInvalidEventReference,
+		},
+		{
+			name:    "garbage",
+			value:   "hello",
+			wantErr: ErrInvalidEventReference,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEventReference(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCoordinate(t *testing.T) {
+	pubkey := strings.Repeat("cd", 32)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    *Coordinate
+		wantErr error
+	}{
+		{"addressable", "30023:" + pubkey + ":post", &Coordinate{30023, pubkey, "post"}, nil},
+		{"identifier with colons", "30023:" + pubkey + ":a:b", &Coordinate{30023, pubkey, "a:b"}, nil},
+		{"replaceable", "0:" + pubkey + ":", &Coordinate{0, pubkey, ""}, nil},
+		{"regular kind", "1:" + pubkey + ":", nil, ErrInvalidCoordinate},
+		{"invalid kind", "x:" + pubkey + ":post", nil, ErrInvalidCoordinate},
+		{"invalid pubkey", "30023:npub:post", nil, ErrInvalidCoordinate},
+		{"missing identifier", "30023:" + pubkey, nil, ErrInvalidCoordinate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCoordinate(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got != nil && got.String() != tt.value {
+				t.Errorf("got string %q, want %q", got.String(), tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package fiat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStaleRate = errors.New("exchange rate is stale")
+)
+
+type cachedRate struct {
+	satsPerUnit float64
+	fetchedAt   time.Time
+}
+
+// rateFetch is a request to the provider, shared by the lookups of the same currency made while it runs.
+type rateFetch struct {
+	done        chan struct{}
+	satsPerUnit float64
+	err         error
+}
+
+type cached struct {
+	provider RateProvider
+	ttl      time.Duration
+	maxAge   time.Duration
+
+	mu      sync.Mutex
+	rates   map[string]*cachedRate
+	fetches map[string]*rateFetch
+}
+
+// NewCached wraps a RateProvider so each rate is fetched at most once per ttl. When refreshing fails the last
+// rate keeps being used until it is older than maxAge, after which the error is returned, wrapped in ErrStaleRate.
+func NewCached(p RateProvider, ttl time.Duration, maxAge time.Duration) RateProvider {
+	if maxAge < ttl {
+		maxAge = ttl
+	}
+
+	return &cached{
+		provider: p,
+		ttl:      ttl,
+		maxAge:   maxAge,
+		rates:    make(map[string]*cachedRate),
+		fetches:  make(map[string]*rateFetch),
+	}
+}
+
+func (c *cached) SatsPerUnit(ctx context.Context, currency string) (float64, error) {
+	currency = strings.ToUpper(currency)
+
+	c.mu.Lock()
+	rate, ok := c.rates[currency]
+	if ok && time.Since(rate.fetchedAt) < c.ttl {
+		c.mu.Unlock()
+		return rate.satsPerUnit, nil
+	}
+	fetch, fetching := c.fetches[currency]
+	if !fetching {
+		fetch = &rateFetch{done: make(chan struct{})}
+		c.fetches[currency] = fetch
+	}
+	c.mu.Unlock()
+
+	if fetching {
+		select {
+		case <-fetch.done:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	} else {
+		c.fetch(ctx, currency, fetch)
+	}
+
+	if fetch.err == nil {
+		return fetch.satsPerUnit, nil
+	}
+	if ok && time.Since(rate.fetchedAt) < c.maxAge {
+		return rate.satsPerUnit, nil
+	}
+	if ok {
+		return 0, errors.Join(ErrStaleRate, fetch.err)
+	}
+
+	return 0, fetch.err
+}
+
+// fetch asks the provider for the rate without holding the lock, so a slow provider doesn't block the lookups of
+// rates that are cached.
+func (c *cached) fetch(ctx context.Context, currency string, fetch *rateFetch) {
+	fetch.satsPerUnit, fetch.err = c.provider.SatsPerUnit(ctx, currency)
+
+	c.mu.Lock()
+	if fetch.err == nil {
+		c.rates[currency] = &cachedRate{
+			satsPerUnit: fetch.satsPerUnit,
+			fetchedAt:   time.Now(),
+		}
+	}
+	delete(c.fetches, currency)
+	c.mu.Unlock()
+
+	close(fetch.done)
+}
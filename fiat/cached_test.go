@@ -0,0 +1,106 @@
+package fiat
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedProvider answers once its gate is open, counting the calls made for each currency.
+type gatedProvider struct {
+	gate  chan struct{}
+	err   error
+	calls sync.Map
+}
+
+func (p *gatedProvider) SatsPerUnit(ctx context.Context, currency string) (float64, error) {
+	calls, _ := p.calls.LoadOrStore(currency, &atomic.Int64{})
+	calls.(*atomic.Int64).Add(1)
+
+	select {
+	case <-p.gate:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+
+	return 1000, nil
+}
+
+func (p *gatedProvider) callsFor(currency string) int64 {
+	calls, ok := p.calls.Load(currency)
+	if !ok {
+		return 0
+	}
+
+	return calls.(*atomic.Int64).Load()
+}
+
+func TestCachedFetchesOutsideTheLock(t *testing.T) {
+	ctx := context.Background()
+	provider := &gatedProvider{gate: make(chan struct{})}
+	c := NewCached(provider, time.Hour, time.Hour)
+
+	close(provider.gate)
+	if _, err := c.SatsPerUnit(ctx, "usd"); err != nil {
+		t.Fatal(err)
+	}
+	provider.gate = make(chan struct{})
+
+	// two lookups of a rate not cached yet share a fetch that hangs.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rate, err := c.SatsPerUnit(ctx, "EUR"); err != nil || rate != 1000 {
+				t.Errorf("got rate %v and error %v", rate, err)
+			}
+		}()
+	}
+	for provider.callsFor("EUR") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	lookup, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if rate, err := c.SatsPerUnit(lookup, "USD"); err != nil || rate != 1000 {
+		t.Fatalf("cached rate blocked by a fetch: got rate %v and error %v", rate, err)
+	}
+
+	close(provider.gate)
+	wg.Wait()
+	if calls := provider.callsFor("EUR"); calls != 1 {
+		t.Errorf("got %d fetches, want 1", calls)
+	}
+}
+
+func TestCachedKeepsStaleRates(t *testing.T) {
+	ctx := context.Background()
+	provider := &gatedProvider{gate: make(chan struct{})}
+	close(provider.gate)
+	c := NewCached(provider, time.Millisecond, 50*time.Millisecond)
+
+	if _, err := c.SatsPerUnit(ctx, "USD"); err != nil {
+		t.Fatal(err)
+	}
+
+	provider.err = errors.New("provider down")
+	time.Sleep(5 * time.Millisecond)
+	if rate, err := c.SatsPerUnit(ctx, "USD"); err != nil || rate != 1000 {
+		t.Fatalf("got rate %v and error %v, want the last rate", rate, err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := c.SatsPerUnit(ctx, "USD"); !errors.Is(err, ErrStaleRate) || !errors.Is(err, provider.err) {
+		t.Fatalf("got error %v, want %v", err, ErrStaleRate)
+	}
+	if _, err := c.SatsPerUnit(ctx, "EUR"); !errors.Is(err, provider.err) || errors.Is(err, ErrStaleRate) {
+		t.Fatalf("got error %v, want %v", err, provider.err)
+	}
+}
@@ -0,0 +1,56 @@
+// Package fiat converts prices set in fiat currencies to sats, using pluggable exchange rate providers.
+package fiat
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("unknown currency")
+	ErrInvalidRate     = errors.New("invalid exchange rate")
+)
+
+type RateProvider interface {
+	// SatsPerUnit returns how many sats one unit of the currency is worth, e.g. sats per 1 USD.
+	SatsPerUnit(ctx context.Context, currency string) (float64, error)
+}
+
+// minorUnitDigits lists the currencies that don't have two decimal digits.
+var minorUnitDigits = map[string]int{
+	"BHD": 3,
+	"CLP": 0,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+	"PYG": 0,
+	"TND": 3,
+	"UGX": 0,
+	"VND": 0,
+}
+
+// ToSats converts an amount in the minor unit of the currency (e.g. cents for USD) to sats, rounding up so the
+// price is never undercharged.
+func ToSats(ctx context.Context, p RateProvider, amountMinor int64, currency string) (int64, error) {
+	currency = strings.ToUpper(currency)
+
+	rate, err := p.SatsPerUnit(ctx, currency)
+	if err != nil {
+		return 0, err
+	}
+	if rate <= 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return 0, ErrInvalidRate
+	}
+
+	digits, ok := minorUnitDigits[currency]
+	if !ok {
+		digits = 2
+	}
+
+	return int64(math.Ceil(float64(amountMinor) / math.Pow10(digits) * rate)), nil
+}
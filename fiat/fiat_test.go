@@ -0,0 +1,78 @@
+package fiat
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestToSats(t *testing.T) {
+	rates := NewStatic(map[string]float64{"usd": 1500, "jpy": 10, "kwd": 5000, "bad": math.NaN()})
+
+	tests := []struct {
+		name        string
+		amountMinor int64
+		currency    string
+		want        int64
+		wantErr     error
+	}{
+		{"cents", 199, "USD", 2985, nil},
+		{"rounds up", 1, "usd", 15, nil},
+		{"no minor unit", 100, "JPY", 1000, nil},
+		{"three digits", 1, "KWD", 5, nil},
+		{"unknown currency", 100, "EUR", 0, ErrUnknownCurrency},
+		{"invalid rate", 100, "BAD", 0, ErrInvalidRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToSats(context.Background(), rates, tt.amountMinor, tt.currency)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d sats, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMempool(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"time": 1700000000, "USD": 50000, "EUR": 0}`))
+	}))
+	defer server.Close()
+
+	p := NewMempool(server.Client(), server.URL)
+
+	tests := []struct {
+		name     string
+		status   int
+		currency string
+		want     float64
+		wantErr  bool
+	}{
+		{"price", http.StatusOK, "usd", 2000, false},
+		{"zero price", http.StatusOK, "EUR", 0, true},
+		{"unknown currency", http.StatusOK, "GBP", 0, true},
+		{"server error", http.StatusInternalServerError, "USD", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+			got, err := p.SatsPerUnit(context.Background(), tt.currency)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v sats per unit, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package fiat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	MempoolPricesURL = "https://mempool.space/api/v1/prices"
+	satsPerBitcoin   = 100_000_000
+)
+
+type mempool struct {
+	url    string
+	client *http.Client
+}
+
+// NewMempool returns a RateProvider backed by the mempool.space prices API, or by any server with the same API
+// when url is not empty. Every call hits the API: wrap it with NewCached.
+func NewMempool(client *http.Client, url string) RateProvider {
+	if url == "" {
+		url = MempoolPricesURL
+	}
+
+	return &mempool{
+		url:    url,
+		client: client,
+	}
+}
+
+func (m *mempool) SatsPerUnit(ctx context.Context, currency string) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.url, http.NoBody)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return 0, fmt.Errorf("mempool: unexpected status %d", res.StatusCode)
+	}
+
+	prices := make(map[string]json.Number)
+	if err := json.NewDecoder(res.Body).Decode(&prices); err != nil {
+		return 0, err
+	}
+
+	price, ok := prices[strings.ToUpper(currency)]
+	if !ok {
+		return 0, ErrUnknownCurrency
+	}
+
+	bitcoinPrice, err := price.Float64()
+	if err != nil || bitcoinPrice <= 0 {
+		return 0, ErrInvalidRate
+	}
+
+	return satsPerBitcoin / bitcoinPrice, nil
+}
@@ -0,0 +1,32 @@
+package fiat
+
+import (
+	"context"
+	"strings"
+)
+
+type static struct {
+	rates map[string]float64
+}
+
+// NewStatic returns a RateProvider with fixed rates, in sats per unit of each currency. Useful for tests, or to
+// pin prices to an agreed rate.
+func NewStatic(satsPerUnit map[string]float64) RateProvider {
+	rates := make(map[string]float64, len(satsPerUnit))
+	for currency, rate := range satsPerUnit {
+		rates[strings.ToUpper(currency)] = rate
+	}
+
+	return &static{
+		rates: rates,
+	}
+}
+
+func (s *static) SatsPerUnit(ctx context.Context, currency string) (float64, error) {
+	rate, ok := s.rates[strings.ToUpper(currency)]
+	if !ok {
+		return 0, ErrUnknownCurrency
+	}
+
+	return rate, nil
+}
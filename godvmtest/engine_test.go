@@ -0,0 +1,245 @@
+package godvmtest_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/sebdeveloper6952/godvm"
+	"github.com/sebdeveloper6952/godvm/godvmtest"
+	"github.com/sebdeveloper6952/godvm/lightning/fake"
+)
+
+// upperDVM answers text generation requests with their text input in upper case.
+type upperDVM struct {
+	sk string
+	pk string
+}
+
+func newUpperDVM() *upperDVM {
+	sk := goNostr.GeneratePrivateKey()
+	pk, _ := goNostr.GetPublicKey(sk)
+
+	return &upperDVM{sk: sk, pk: pk}
+}
+
+func (d *upperDVM) PublicKeyHex() string {
+	return d.pk
+}
+
+func (d *upperDVM) KindSupported() int {
+	return godvm.KindReqTextGeneration
+}
+
+func (d *upperDVM) Version() string {
+	return "upper-test"
+}
+
+func (d *upperDVM) Profile() *godvm.ProfileMetadata {
+	return &godvm.ProfileMetadata{Name: "upper"}
+}
+
+func (d *upperDVM) Sign(e *goNostr.Event) error {
+	return e.Sign(d.sk)
+}
+
+func (d *upperDVM) Run(
+	ctx context.Context,
+	input *godvm.Nip90Input,
+	chanToDvm <-chan *godvm.JobUpdate,
+	chanToEngine chan<- *godvm.JobUpdate,
+) bool {
+	if len(input.Inputs) != 1 || input.Inputs[0].Type != godvm.InputTypeText {
+		return false
+	}
+
+	go func() {
+		chanToEngine <- &godvm.JobUpdate{Status: godvm.StatusProcessing}
+		chanToEngine <- &godvm.JobUpdate{
+			Status: godvm.StatusSuccess,
+			Result: strings.ToUpper(input.Inputs[0].Value),
+		}
+	}()
+
+	return true
+}
+
+// paidDVM is an upperDVM that asks for priceSats before answering.
+type paidDVM struct {
+	*upperDVM
+	priceSats int
+}
+
+func (d *paidDVM) Run(
+	ctx context.Context,
+	input *godvm.Nip90Input,
+	chanToDvm <-chan *godvm.JobUpdate,
+	chanToEngine chan<- *godvm.JobUpdate,
+) bool {
+	if len(input.Inputs) != 1 || input.Inputs[0].Type != godvm.InputTypeText {
+		return false
+	}
+
+	go func() {
+		chanToEngine <- &godvm.JobUpdate{
+			Status:     godvm.StatusPaymentRequired,
+			AmountSats: d.priceSats,
+		}
+
+		for update := range chanToDvm {
+			if update.Status != godvm.StatusPaymentCompleted {
+				return
+			}
+
+			chanToEngine <- &godvm.JobUpdate{
+				Status: godvm.StatusSuccess,
+				Result: strings.ToUpper(input.Inputs[0].Value),
+			}
+			return
+		}
+	}()
+
+	return true
+}
+
+func TestEngineRun(t *testing.T) {
+	relay, err := godvmtest.NewRelay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	engine, err := godvm.NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.RegisterDVM(newUpperDVM())
+	if err := engine.Run(ctx, []string{relay.URL()}); err != nil {
+		t.Fatal(err)
+	}
+
+	customer := goNostr.GeneratePrivateKey()
+	customerPubkey, _ := goNostr.GetPublicKey(customer)
+	request, err := godvmtest.PublishJobRequest(ctx, relay.URL(), customer, godvmtest.JobRequest{
+		Kind:   godvm.KindReqTextGeneration,
+		Inputs: [][]string{{"hello", godvm.InputTypeText}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedback, err := godvmtest.WaitForFeedback(ctx, relay.URL(), request, "processing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	godvmtest.RequireTag(t, feedback, "p", customerPubkey)
+
+	result, err := godvmtest.WaitForResult(ctx, relay.URL(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "HELLO" {
+		t.Fatalf("result content %q, want %q", result.Content, "HELLO")
+	}
+	godvmtest.RequireTag(t, result, "e", request.ID)
+	godvmtest.RequireTag(t, result, "p", customerPubkey)
+	godvmtest.RequireTag(t, result, "i", "hello", godvm.InputTypeText)
+}
+
+func TestEngineRunRejectsInvalidRequest(t *testing.T) {
+	relay, err := godvmtest.NewRelay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	engine, err := godvm.NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.RegisterDVM(newUpperDVM())
+	if err := engine.Run(ctx, []string{relay.URL()}); err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := godvmtest.PublishJobRequest(ctx, relay.URL(), goNostr.GeneratePrivateKey(), godvmtest.JobRequest{
+		Kind:   godvm.KindReqTextGeneration,
+		Inputs: [][]string{{"hello", godvm.InputTypeText}},
+		Tags:   goNostr.Tags{{"bid", "ten"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedback, err := godvmtest.WaitForFeedback(ctx, relay.URL(), request, "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	godvmtest.RequireTag(t, feedback, "status", "error", `invalid job request: bid "ten" is not an amount of millisats`)
+}
+
+func TestEngineRunPaidJob(t *testing.T) {
+	relay, err := godvmtest.NewRelay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ln, err := fake.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := godvm.NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.SetLnService(ln)
+	engine.RegisterDVM(&paidDVM{upperDVM: newUpperDVM(), priceSats: 21})
+	if err := engine.Run(ctx, []string{relay.URL()}); err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := godvmtest.PublishJobRequest(ctx, relay.URL(), goNostr.GeneratePrivateKey(), godvmtest.JobRequest{
+		Kind:   godvm.KindReqTextGeneration,
+		Inputs: [][]string{{"hello", godvm.InputTypeText}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feedback, err := godvmtest.WaitForFeedback(ctx, relay.URL(), request, "payment-required")
+	if err != nil {
+		t.Fatal(err)
+	}
+	amount := feedback.Tags.GetFirst([]string{"amount", ""})
+	if amount == nil || len(*amount) < 3 || (*amount)[1] != "21000" {
+		t.Fatalf("got amount tag %v, want 21000 msats with an invoice", amount)
+	}
+
+	hash, err := ln.HashFromPayReq((*amount)[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ln.Settle(hash); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := godvmtest.WaitForResult(ctx, relay.URL(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "HELLO" {
+		t.Fatalf("result content %q, want %q", result.Content, "HELLO")
+	}
+}
@@ -0,0 +1,193 @@
+package godvmtest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+// defaultWaitTimeout bounds the waits of the helpers when their context has no deadline.
+const defaultWaitTimeout = 10 * time.Second
+
+const kindJobFeedback = 7000
+
+// JobRequest is a NIP-90 job request to publish.
+type JobRequest struct {
+	Kind int
+
+	// Inputs are the values of each `i` tag: the input, and optionally its type, relay and marker.
+	Inputs [][]string
+
+	Params       [][2]string
+	Output       string
+	BidMillisats int
+	Relays       []string
+
+	// Tags are added to the request as is.
+	Tags    goNostr.Tags
+	Content string
+}
+
+// PublishJobRequest signs the job request with the customer secret key and publishes it to the relay.
+func PublishJobRequest(
+	ctx context.Context,
+	relayURL string,
+	secretKey string,
+	req JobRequest,
+) (*goNostr.Event, error) {
+	event := &goNostr.Event{
+		Kind:      req.Kind,
+		CreatedAt: goNostr.Now(),
+		Content:   req.Content,
+		Tags:      goNostr.Tags{},
+	}
+
+	for _, input := range req.Inputs {
+		event.Tags = append(event.Tags, append(goNostr.Tag{"i"}, input...))
+	}
+	for _, param := range req.Params {
+		event.Tags = append(event.Tags, goNostr.Tag{"param", param[0], param[1]})
+	}
+	if req.Output != "" {
+		event.Tags = append(event.Tags, goNostr.Tag{"output", req.Output})
+	}
+	if req.BidMillisats > 0 {
+		event.Tags = append(event.Tags, goNostr.Tag{"bid", fmt.Sprintf("%d", req.BidMillisats)})
+	}
+	if len(req.Relays) > 0 {
+		event.Tags = append(event.Tags, append(goNostr.Tag{"relays"}, req.Relays...))
+	}
+	event.Tags = append(event.Tags, req.Tags...)
+
+	if err := event.Sign(secretKey); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := waitContext(ctx)
+	defer cancel()
+
+	relay, err := goNostr.RelayConnect(ctx, relayURL)
+	if err != nil {
+		return nil, err
+	}
+	defer relay.Close()
+
+	if err := relay.Publish(ctx, *event); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// WaitForFeedback waits for a feedback event to the job request with the status, e.g. "payment-required", or with
+// any status when it's empty.
+func WaitForFeedback(
+	ctx context.Context,
+	relayURL string,
+	request *goNostr.Event,
+	status string,
+) (*goNostr.Event, error) {
+	filter := goNostr.Filter{
+		Kinds: []int{kindJobFeedback},
+		Tags:  goNostr.TagMap{"e": []string{request.ID}},
+	}
+
+	return waitFor(ctx, relayURL, filter, func(event *goNostr.Event) bool {
+		return status == "" || HasTag(event, "status", status)
+	})
+}
+
+// WaitForResult waits for the result event of the job request.
+func WaitForResult(
+	ctx context.Context,
+	relayURL string,
+	request *goNostr.Event,
+) (*goNostr.Event, error) {
+	filter := goNostr.Filter{
+		Kinds: []int{request.Kind + 1000},
+		Tags:  goNostr.TagMap{"e": []string{request.ID}},
+	}
+
+	return waitFor(ctx, relayURL, filter, func(event *goNostr.Event) bool {
+		return true
+	})
+}
+
+// waitFor returns the first event stored or published on the relay that matches the filter and match.
+func waitFor(
+	ctx context.Context,
+	relayURL string,
+	filter goNostr.Filter,
+	match func(event *goNostr.Event) bool,
+) (*goNostr.Event, error) {
+	ctx, cancel := waitContext(ctx)
+	defer cancel()
+
+	relay, err := goNostr.RelayConnect(ctx, relayURL)
+	if err != nil {
+		return nil, err
+	}
+	defer relay.Close()
+
+	sub, err := relay.Subscribe(ctx, goNostr.Filters{filter})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsub()
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return nil, fmt.Errorf("wait for %s: subscription closed", filter)
+			}
+			if event != nil && match(event) {
+				return event, nil
+			}
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for %s: %w", filter, ctx.Err())
+		}
+	}
+}
+
+// waitContext returns ctx bounded by defaultWaitTimeout when it has no deadline.
+func waitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, defaultWaitTimeout)
+}
+
+// TB is the part of testing.TB the assertions use.
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+}
+
+// HasTag reports whether the event has a tag starting with values, e.g. HasTag(ev, "status", "success").
+func HasTag(event *goNostr.Event, values ...string) bool {
+	for _, tag := range event.Tags {
+		if len(tag) < len(values) {
+			continue
+		}
+		if slices.Equal(tag[:len(values)], values) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequireTag fails the test when the event has no tag starting with values.
+func RequireTag(t TB, event *goNostr.Event, values ...string) {
+	t.Helper()
+
+	if !HasTag(event, values...) {
+		t.Fatalf("event %s has no tag [%s], tags: %v", event.ID, strings.Join(values, " "), event.Tags)
+	}
+}
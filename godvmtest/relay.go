@@ -0,0 +1,243 @@
+// Package godvmtest runs the engine against an in-process relay, so DVMs can be tested end to end without network
+// access: start a Relay, run the engine with its URL, publish job requests with PublishJobRequest and wait for the
+// feedback and results of the DVM with WaitForFeedback and WaitForResult.
+package godvmtest
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+// Relay is a minimal in-memory NIP-01 relay. It stores every event with a valid signature, keeping only the latest
+// version of replaceable and addressable events, and delivers them to the matching subscriptions.
+type Relay struct {
+	listener net.Listener
+	server   *http.Server
+
+	mu     sync.Mutex
+	events []*goNostr.Event
+	conns  map[*relayConn]struct{}
+}
+
+type relayConn struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	subs map[string]goNostr.Filters
+}
+
+// NewRelay starts a relay listening on a random local port. It must be closed with Close.
+func NewRelay() (*Relay, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Relay{
+		listener: listener,
+		conns:    make(map[*relayConn]struct{}),
+	}
+	r.server = &http.Server{Handler: http.HandlerFunc(r.serveHTTP)}
+
+	go func() {
+		_ = r.server.Serve(listener)
+	}()
+
+	return r, nil
+}
+
+// URL returns the websocket URL of the relay.
+func (r *Relay) URL() string {
+	return "ws://" + r.listener.Addr().String()
+}
+
+// Close stops the relay and closes every connection.
+func (r *Relay) Close() error {
+	err := r.server.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for conn := range r.conns {
+		conn.conn.Close()
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Events returns every event stored by the relay, in the order they were received.
+func (r *Relay) Events() []*goNostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*goNostr.Event(nil), r.events...)
+}
+
+// Publish stores the event and delivers it to the matching subscriptions, as if a client published it.
+func (r *Relay) Publish(event *goNostr.Event) error {
+	if ok, err := event.CheckSignature(); !ok || event.GetID() != event.ID {
+		return errors.Join(errors.New("invalid: bad event id or signature"), err)
+	}
+
+	r.mu.Lock()
+	r.store(event)
+	conns := make([]*relayConn, 0, len(r.conns))
+	for conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	r.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.deliver(event)
+	}
+
+	return nil
+}
+
+// store must be called with r.mu held.
+func (r *Relay) store(event *goNostr.Event) {
+	replaceable := event.Kind == 0 || event.Kind == 3 || event.Kind >= 10000 && event.Kind < 20000
+	addressable := event.Kind >= 30000 && event.Kind < 40000
+
+	if replaceable || addressable {
+		d := event.Tags.GetD()
+		for i, stored := range r.events {
+			if stored.Kind != event.Kind || stored.PubKey != event.PubKey || addressable && stored.Tags.GetD() != d {
+				continue
+			}
+			if stored.CreatedAt > event.CreatedAt {
+				return
+			}
+			r.events = append(r.events[:i], r.events[i+1:]...)
+			break
+		}
+	}
+
+	r.events = append(r.events, event)
+}
+
+func (r *Relay) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	netConn, _, _, err := ws.UpgradeHTTP(req, w)
+	if err != nil {
+		return
+	}
+
+	conn := &relayConn{
+		conn: netConn,
+		subs: make(map[string]goNostr.Filters),
+	}
+
+	r.mu.Lock()
+	r.conns[conn] = struct{}{}
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.conns, conn)
+		r.mu.Unlock()
+		netConn.Close()
+	}()
+
+	for {
+		message, err := wsutil.ReadClientText(netConn)
+		if err != nil {
+			return
+		}
+		r.handle(conn, message)
+	}
+}
+
+func (r *Relay) handle(conn *relayConn, message []byte) {
+	switch env := goNostr.ParseMessage(message).(type) {
+	case *goNostr.EventEnvelope:
+		ok := &goNostr.OKEnvelope{EventID: env.Event.ID, OK: true}
+		if err := r.Publish(&env.Event); err != nil {
+			ok.OK = false
+			ok.Reason = err.Error()
+		}
+		conn.write(ok)
+	case *goNostr.ReqEnvelope:
+		conn.mu.Lock()
+		conn.subs[env.SubscriptionID] = env.Filters
+		conn.mu.Unlock()
+
+		for _, event := range r.query(env.Filters) {
+			conn.write(&goNostr.EventEnvelope{SubscriptionID: &env.SubscriptionID, Event: *event})
+		}
+		eose := goNostr.EOSEEnvelope(env.SubscriptionID)
+		conn.write(&eose)
+	case *goNostr.CloseEnvelope:
+		conn.mu.Lock()
+		delete(conn.subs, string(*env))
+		conn.mu.Unlock()
+	}
+}
+
+// query returns the stored events matching the filters, newest first, up to the limit of each filter.
+func (r *Relay) query(filters goNostr.Filters) []*goNostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := make([]*goNostr.Event, 0)
+	seen := make(map[string]struct{})
+	for _, filter := range filters {
+		count := 0
+		for i := len(r.events) - 1; i >= 0; i-- {
+			if filter.Limit > 0 && count >= filter.Limit {
+				break
+			}
+			event := r.events[i]
+			if !filter.Matches(event) {
+				continue
+			}
+			if _, ok := seen[event.ID]; ok {
+				continue
+			}
+			count++
+			seen[event.ID] = struct{}{}
+			matched = append(matched, event)
+		}
+	}
+
+	return matched
+}
+
+// deliver sends the event to every subscription of the connection it matches.
+func (c *relayConn) deliver(event *goNostr.Event) {
+	c.mu.Lock()
+	ids := make([]string, 0)
+	for id, filters := range c.subs {
+		if filters.Match(event) {
+			ids = append(ids, id)
+		}
+	}
+	c.mu.Unlock()
+
+	for i := range ids {
+		c.write(&goNostr.EventEnvelope{SubscriptionID: &ids[i], Event: *event})
+	}
+}
+
+func (c *relayConn) write(env goNostr.Envelope) {
+	message, err := env.MarshalJSON()
+	if err != nil {
+		return
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_ = wsutil.WriteServerText(c.conn, message)
+}
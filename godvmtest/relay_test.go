@@ -0,0 +1,125 @@
+package godvmtest
+
+import (
+	"testing"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+func TestRelayQuery(t *testing.T) {
+	alice := goNostr.GeneratePrivateKey()
+	alicePubkey, _ := goNostr.GetPublicKey(alice)
+	bob := goNostr.GeneratePrivateKey()
+
+	relay := &Relay{conns: make(map[*relayConn]struct{})}
+	publish := func(sk string, kind int, createdAt goNostr.Timestamp, tags goNostr.Tags) *goNostr.Event {
+		event := &goNostr.Event{Kind: kind, CreatedAt: createdAt, Tags: tags}
+		if err := event.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := relay.Publish(event); err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+
+	aliceOld := publish(alice, 1, 100, nil)
+	aliceNew := publish(alice, 1, 200, nil)
+	bobNew := publish(bob, 1, 300, nil)
+	profileOld := publish(alice, 0, 100, nil)
+	profileNew := publish(alice, 0, 200, nil)
+	addressable := publish(alice, 30000, 100, goNostr.Tags{{"d", "a"}})
+
+	tests := []struct {
+		name    string
+		filters goNostr.Filters
+		want    []*goNostr.Event
+	}{
+		{
+			name:    "newest first",
+			filters: goNostr.Filters{{Kinds: []int{1}}},
+			want:    []*goNostr.Event{bobNew, aliceNew, aliceOld},
+		},
+		{
+			name:    "limit",
+			filters: goNostr.Filters{{Kinds: []int{1}, Limit: 1}},
+			want:    []*goNostr.Event{bobNew},
+		},
+		{
+			name: "duplicates don't count against the limit",
+			filters: goNostr.Filters{
+				{Kinds: []int{1}, Limit: 2},
+				{Kinds: []int{1}, Authors: []string{alicePubkey}, Limit: 1},
+			},
+			want: []*goNostr.Event{bobNew, aliceNew, aliceOld},
+		},
+		{
+			name:    "replaceable keeps the latest",
+			filters: goNostr.Filters{{Kinds: []int{0}}},
+			want:    []*goNostr.Event{profileNew},
+		},
+		{
+			name:    "addressable by d tag",
+			filters: goNostr.Filters{{Kinds: []int{30000}, Tags: goNostr.TagMap{"d": []string{"a"}}}},
+			want:    []*goNostr.Event{addressable},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := relay.query(tt.filters)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i].ID {
+					t.Fatalf("event %d is %s, want %s", i, got[i].ID, tt.want[i].ID)
+				}
+			}
+		})
+	}
+
+	for _, event := range relay.Events() {
+		if event.ID == profileOld.ID {
+			t.Fatal("replaced profile still stored")
+		}
+	}
+}
+
+func TestRelayPublishRejectsInvalidSignature(t *testing.T) {
+	relay := &Relay{conns: make(map[*relayConn]struct{})}
+
+	event := &goNostr.Event{Kind: 1, CreatedAt: goNostr.Now(), Content: "hello"}
+	if err := event.Sign(goNostr.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	event.Content = "tampered"
+
+	if err := relay.Publish(event); err == nil {
+		t.Fatal("tampered event accepted")
+	}
+}
+
+func TestHasTag(t *testing.T) {
+	event := &goNostr.Event{Tags: goNostr.Tags{{"status", "error", "bad bid"}, {"e"}}}
+
+	tests := []struct {
+		values []string
+		want   bool
+	}{
+		{[]string{"status"}, true},
+		{[]string{"status", "error"}, true},
+		{[]string{"status", "error", "bad bid"}, true},
+		{[]string{"status", "success"}, false},
+		{[]string{"status", "error", "bad bid", "extra"}, false},
+		{[]string{"e"}, true},
+		{[]string{"e", ""}, false},
+		{[]string{"p"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := HasTag(event, tt.values...); got != tt.want {
+			t.Errorf("HasTag(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
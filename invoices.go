@@ -0,0 +1,389 @@
+package godvm
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/sebdeveloper6952/godvm/invoices"
+	"github.com/sebdeveloper6952/godvm/ledger"
+	"github.com/sebdeveloper6952/godvm/lightning"
+)
+
+// invoiceExpiryGrace is how long past its expiry an invoice is still watched, in case the backend reports the
+// settlement late.
+const invoiceExpiryGrace = time.Minute
+
+// SetInvoiceStore makes the engine persist the invoices it is waiting on, so they are watched again when the engine
+// runs after a restart. Job invoices paid after a restart are recorded in the ledger, since the job itself is lost.
+// Top-up invoices are credited as usual.
+func (e *Engine) SetInvoiceStore(s invoices.Store) {
+	e.pendingInvoices = s
+}
+
+// resumePendingInvoices watches the invoices persisted by a previous run.
+func (e *Engine) resumePendingInvoices(ctx context.Context) {
+	if e.pendingInvoices == nil {
+		return
+	}
+
+	pending, err := e.pendingInvoices.List(ctx)
+	if err != nil {
+		e.log.Printf("list pending invoices %+v", err)
+		return
+	}
+
+	for _, p := range pending {
+		p := p
+		hash, err := lntypes.MakeHashFromStr(p.Hash)
+		if err != nil {
+			e.log.Printf("pending invoice %s %+v", p.Hash, err)
+			e.deletePendingInvoice(p.Hash)
+			continue
+		}
+
+		lnSvc := e.lnSvc
+		if dvm := e.dvmByPubkey(p.DvmPubkey); dvm != nil {
+			lnSvc, err = e.lnServiceFor(dvm)
+		}
+		if lnSvc == nil || err != nil {
+			e.log.Printf("no lightning service to watch pending invoice %s", p.Hash)
+			continue
+		}
+
+		invoice := &lightning.Invoice{
+			Hash:   hash,
+			PayReq: p.PayReq,
+		}
+		e.watcher.resume(lnSvc, invoice, func(err error) {
+			e.deletePendingInvoice(p.Hash)
+			if err != nil {
+				return
+			}
+			e.settleResumedInvoice(p)
+		})
+	}
+}
+
+func (e *Engine) settleResumedInvoice(p *invoices.Pending) {
+	ctx := context.Background()
+
+	switch p.Purpose {
+	case invoices.PurposeTopUp:
+		e.creditTopUp(ctx, p.CustomerPubkey, p.AmountSats, p.Hash)
+	case invoices.PurposeJob:
+		e.log.Printf("invoice %s of job %s paid after restart", p.Hash, p.JobID)
+		if e.ledger == nil {
+			return
+		}
+		err := e.ledger.Update(ctx, p.JobID, p.DvmPubkey, func(entry *ledger.Entry) {
+			entry.AmountPaidSats += p.AmountSats
+			entry.PaidAt = time.Now()
+		})
+		if err != nil {
+			e.log.Printf("record job %s %+v", p.JobID, err)
+		}
+	}
+}
+
+func (e *Engine) savePendingInvoice(ctx context.Context, p *invoices.Pending) {
+	if e.pendingInvoices == nil {
+		return
+	}
+
+	if err := e.pendingInvoices.Save(ctx, p); err != nil {
+		e.log.Printf("save pending invoice %s %+v", p.Hash, err)
+	}
+}
+
+func (e *Engine) deletePendingInvoice(hash string) {
+	if e.pendingInvoices == nil {
+		return
+	}
+
+	if err := e.pendingInvoices.Delete(context.Background(), hash); err != nil {
+		e.log.Printf("delete pending invoice %s %+v", hash, err)
+	}
+}
+
+func (e *Engine) dvmByPubkey(pubkey string) Dvmer {
+	for _, dvms := range e.dvmsByKind {
+		for i := range dvms {
+			if dvms[i].PublicKeyHex() == pubkey {
+				return dvms[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// invoiceWatcher waits on every invoice issued by the engine. Invoices of services that implement
+// lightning.InvoiceSubscriber share a single subscription per service, routed to their watch by payment hash. The
+// others are tracked one by one with TrackInvoice.
+type invoiceWatcher struct {
+	log *log.Logger
+
+	mu       sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	backends map[lightning.Service]*invoiceBackend
+}
+
+type invoiceBackend struct {
+	svc     lightning.Service
+	watches map[lntypes.Hash]*invoiceWatch
+
+	// sub is the running subscription of an InvoiceSubscriber service, nil when there is none.
+	sub *invoiceSubscription
+}
+
+type invoiceSubscription struct {
+	cancel context.CancelFunc
+}
+
+type invoiceWatch struct {
+	invoice *lightning.Invoice
+	done    func(err error)
+	expiry  *time.Timer
+
+	// cancel stops the TrackInvoice call of an invoice tracked on its own.
+	cancel context.CancelFunc
+}
+
+func newInvoiceWatcher(logger *log.Logger) *invoiceWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &invoiceWatcher{
+		log:      logger,
+		ctx:      ctx,
+		cancel:   cancel,
+		backends: make(map[lightning.Service]*invoiceBackend),
+	}
+}
+
+// watch calls done once the invoice settles, with a nil error, or once it can no longer be paid. The returned
+// function stops watching the invoice without calling done.
+func (w *invoiceWatcher) watch(svc lightning.Service, invoice *lightning.Invoice, done func(err error)) func() {
+	return w.add(svc, invoice, done, false)
+}
+
+// resume watches an invoice issued before a restart. It's always tracked on its own with TrackInvoice, which reports
+// its current state: a shared subscription only reports settlements from the time it starts, and the invoice may
+// have been paid while the engine was down.
+func (w *invoiceWatcher) resume(svc lightning.Service, invoice *lightning.Invoice, done func(err error)) func() {
+	return w.add(svc, invoice, done, true)
+}
+
+func (w *invoiceWatcher) add(
+	svc lightning.Service,
+	invoice *lightning.Invoice,
+	done func(err error),
+	tracked bool,
+) func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	backend, ok := w.backends[svc]
+	if !ok {
+		backend = &invoiceBackend{
+			svc:     svc,
+			watches: make(map[lntypes.Hash]*invoiceWatch),
+		}
+		w.backends[svc] = backend
+	}
+
+	watch := &invoiceWatch{
+		invoice: invoice,
+		done:    done,
+	}
+	if old, ok := backend.watches[invoice.Hash]; ok {
+		old.stop()
+	}
+	backend.watches[invoice.Hash] = watch
+
+	if expiresAt, err := invoiceExpiresAt(invoice); err == nil {
+		// an invoice that expired while the engine was down still gets the grace period to report its settlement.
+		delay := max(time.Until(expiresAt)+invoiceExpiryGrace, invoiceExpiryGrace)
+		watch.expiry = time.AfterFunc(delay, func() {
+			w.resolve(backend, watch, lightning.ErrInvoiceExpired)
+		})
+	}
+
+	if subscriber, ok := svc.(lightning.InvoiceSubscriber); ok && !tracked {
+		if backend.sub == nil {
+			w.subscribe(backend, subscriber)
+		}
+	} else {
+		w.track(backend, watch)
+	}
+
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		w.remove(backend, watch)
+	}
+}
+
+// stop cancels every subscription and tracked invoice. Pending watches are dropped without calling done. The
+// watcher can be used again afterwards, e.g. by the next Run of the engine.
+func (w *invoiceWatcher) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.cancel()
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	for _, backend := range w.backends {
+		for _, watch := range backend.watches {
+			watch.stop()
+		}
+		backend.watches = make(map[lntypes.Hash]*invoiceWatch)
+		backend.sub = nil
+	}
+}
+
+// resolve removes the watch and calls its done function, unless it was already removed.
+func (w *invoiceWatcher) resolve(backend *invoiceBackend, watch *invoiceWatch, err error) {
+	w.mu.Lock()
+	if backend.watches[watch.invoice.Hash] != watch {
+		w.mu.Unlock()
+		return
+	}
+	w.remove(backend, watch)
+	w.mu.Unlock()
+
+	watch.done(err)
+}
+
+// remove must be called with w.mu held. The subscription of the backend stops with its last watch.
+func (w *invoiceWatcher) remove(backend *invoiceBackend, watch *invoiceWatch) {
+	if backend.watches[watch.invoice.Hash] != watch {
+		return
+	}
+
+	delete(backend.watches, watch.invoice.Hash)
+	watch.stop()
+
+	if len(backend.watches) == 0 && backend.sub != nil {
+		backend.sub.cancel()
+		backend.sub = nil
+	}
+}
+
+// subscribe must be called with w.mu held.
+func (w *invoiceWatcher) subscribe(backend *invoiceBackend, subscriber lightning.InvoiceSubscriber) {
+	ctx, cancel := context.WithCancel(w.ctx)
+	sub := &invoiceSubscription{cancel: cancel}
+	backend.sub = sub
+
+	updates, errs := subscriber.SubscribeInvoices(ctx)
+
+	go func() {
+		for {
+			select {
+			case update, ok := <-updates:
+				if !ok {
+					w.fallBack(backend, sub, nil)
+					return
+				}
+				if !update.Settled && update.Err == nil {
+					continue
+				}
+
+				w.mu.Lock()
+				watch, ok := backend.watches[update.Hash]
+				w.mu.Unlock()
+				if !ok {
+					continue
+				}
+
+				if update.Settled {
+					w.resolve(backend, watch, nil)
+				} else {
+					w.resolve(backend, watch, update.Err)
+				}
+			case err, ok := <-errs:
+				if !ok {
+					err = nil
+				}
+				w.fallBack(backend, sub, err)
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// fallBack tracks the invoices still waiting on a subscription that ended one by one. The next watch on the
+// backend starts a new subscription.
+func (w *invoiceWatcher) fallBack(backend *invoiceBackend, sub *invoiceSubscription, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if backend.sub != sub {
+		return
+	}
+	backend.sub.cancel()
+	backend.sub = nil
+
+	if err != nil {
+		w.log.Printf("invoice subscription ended, tracking %d invoices one by one %+v", len(backend.watches), err)
+	}
+
+	for _, watch := range backend.watches {
+		if watch.cancel == nil {
+			w.track(backend, watch)
+		}
+	}
+}
+
+// track must be called with w.mu held.
+func (w *invoiceWatcher) track(backend *invoiceBackend, watch *invoiceWatch) {
+	ctx, cancel := context.WithCancel(w.ctx)
+	watch.cancel = cancel
+
+	go func() {
+		updates, errs := backend.svc.TrackInvoice(ctx, watch.invoice)
+		for {
+			select {
+			case update, ok := <-updates:
+				if !ok {
+					return
+				}
+				if update.Settled {
+					w.resolve(backend, watch, nil)
+					return
+				}
+			case err, ok := <-errs:
+				if ok {
+					w.resolve(backend, watch, err)
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (watch *invoiceWatch) stop() {
+	if watch.expiry != nil {
+		watch.expiry.Stop()
+	}
+	if watch.cancel != nil {
+		watch.cancel()
+	}
+}
+
+func invoiceExpiresAt(invoice *lightning.Invoice) (time.Time, error) {
+	decoded, err := lightning.DecodePayReq(invoice.PayReq)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return decoded.Timestamp.Add(decoded.Expiry()), nil
+}
@@ -0,0 +1,39 @@
+// Package invoices keeps the invoices the engine is waiting to be paid, so they can be watched again after a
+// restart.
+package invoices
+
+import (
+	"context"
+	"time"
+)
+
+// Purpose tells what an invoice pays for.
+type Purpose string
+
+const (
+	PurposeJob   Purpose = "job"
+	PurposeTopUp Purpose = "topup"
+)
+
+// Pending is an invoice that was issued and is neither paid nor expired yet.
+type Pending struct {
+	Hash           string    `json:"hash"`
+	PayReq         string    `json:"pay_req"`
+	Purpose        Purpose   `json:"purpose"`
+	JobID          string    `json:"job_id,omitempty"`
+	DvmPubkey      string    `json:"dvm_pubkey,omitempty"`
+	CustomerPubkey string    `json:"customer_pubkey"`
+	AmountSats     int64     `json:"amount_sats"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type Store interface {
+	// Save adds the invoice, replacing any other with the same hash.
+	Save(ctx context.Context, p *Pending) error
+
+	// Delete removes the invoice. Deleting an unknown hash is not an error.
+	Delete(ctx context.Context, hash string) error
+
+	// List returns copies of every pending invoice, oldest first.
+	List(ctx context.Context) ([]*Pending, error)
+}
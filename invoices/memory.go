@@ -0,0 +1,92 @@
+package invoices
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/sebdeveloper6952/godvm/internal/filestore"
+)
+
+type memory struct {
+	mu      sync.Mutex
+	path    string
+	pending map[string]*Pending
+}
+
+// NewMemory returns a Store that only lives in memory.
+func NewMemory() Store {
+	return &memory{
+		pending: make(map[string]*Pending),
+	}
+}
+
+// NewFile returns a Store kept in memory and saved as JSON to path after every change. Existing invoices in path
+// are loaded first.
+func NewFile(path string) (Store, error) {
+	m := &memory{
+		path:    path,
+		pending: make(map[string]*Pending),
+	}
+
+	pending := make([]*Pending, 0)
+	if err := filestore.Load(path, &pending); err != nil {
+		return nil, err
+	}
+	for _, p := range pending {
+		m.pending[p.Hash] = p
+	}
+
+	return m, nil
+}
+
+func (m *memory) Save(ctx context.Context, p *Pending) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := *p
+	m.pending[p.Hash] = &saved
+
+	return m.save()
+}
+
+func (m *memory) Delete(ctx context.Context, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pending[hash]; !ok {
+		return nil
+	}
+	delete(m.pending, hash)
+
+	return m.save()
+}
+
+func (m *memory) List(ctx context.Context) ([]*Pending, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.list(), nil
+}
+
+func (m *memory) list() []*Pending {
+	pending := make([]*Pending, 0, len(m.pending))
+	for _, p := range m.pending {
+		copied := *p
+		pending = append(pending, &copied)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+
+	return pending
+}
+
+func (m *memory) save() error {
+	if m.path == "" {
+		return nil
+	}
+
+	return filestore.Save(m.path, m.list())
+}
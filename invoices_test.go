@@ -0,0 +1,130 @@
+package godvm
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/sebdeveloper6952/godvm/credits"
+	"github.com/sebdeveloper6952/godvm/invoices"
+	"github.com/sebdeveloper6952/godvm/lightning"
+	"github.com/sebdeveloper6952/godvm/lightning/fake"
+)
+
+func TestResumePendingInvoices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ln, err := fake.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.watcher.stop()
+	e.SetLnService(ln)
+	e.EnableCredits(CreditsConfig{Store: credits.NewMemory()})
+	pending := invoices.NewMemory()
+	e.SetInvoiceStore(pending)
+
+	// invoices issued by a previous run, for two customers.
+	topUps := []*invoices.Pending{
+		{Purpose: invoices.PurposeTopUp, CustomerPubkey: "alice", AmountSats: 10},
+		{Purpose: invoices.PurposeTopUp, CustomerPubkey: "bob", AmountSats: 20},
+	}
+	for _, p := range topUps {
+		invoice, err := ln.AddInvoice(ctx, p.AmountSats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Hash = invoice.Hash.String()
+		p.PayReq = invoice.PayReq
+		p.CreatedAt = time.Now()
+		if err := pending.Save(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e.resumePendingInvoices(ctx)
+
+	if err := ln.Settle(ln.Invoices()[0].Hash); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		left, err := pending.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(left) == 1 {
+			if left[0].Hash != topUps[1].Hash {
+				t.Fatalf("invoice of bob removed instead of alice's")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the settled invoice to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for customer, want := range map[string]int64{"alice": 10, "bob": 0} {
+		balance, err := e.Balance(ctx, customer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance != want {
+			t.Errorf("got balance %d for %s, want %d", balance, customer, want)
+		}
+	}
+}
+
+func TestInvoiceWatcherStop(t *testing.T) {
+	ctx := context.Background()
+
+	ln, err := fake.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newInvoiceWatcher(log.New(io.Discard, "", 0))
+
+	// invoices tracked one by one, and through a subscription.
+	backends := []lightning.Service{struct{ lightning.Service }{ln}, ln}
+	for _, backend := range backends {
+		invoice, err := ln.AddInvoice(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.watch(backend, invoice, func(err error) {
+			t.Errorf("invoice watched before stopping done with error %v", err)
+		})
+		w.stop()
+
+		done := make(chan error, 1)
+		invoice, err = ln.AddInvoice(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.watch(backend, invoice, func(err error) {
+			done <- err
+		})
+		if err := ln.Settle(invoice.Hash); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("got error %v, want a settlement", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("invoice watched after stopping never settled")
+		}
+		w.stop()
+	}
+}
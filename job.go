@@ -1,5 +1,10 @@
 package godvm
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 type Job struct {
 	ID string
 }
@@ -34,4 +39,96 @@ type JobUpdate struct {
 	Result         string
 	ExtraTags      [][]string
 	FailureMsg     string
+
+	// FiatAmount is the price in the minor unit of FiatCurrency, e.g. cents for USD. When set on StatusPaymentRequired
+	// or StatusSuccessWithPayment, the engine converts it to sats with its rate provider and overwrites AmountSats.
+	// See Engine.SetRateProvider.
+	FiatAmount   int64
+	FiatCurrency string
+
+	// RefundSats is the amount refunded to the customer when a paid job ends with StatusError. When zero, the
+	// engine refund policy decides. Only used when refunds are enabled, see Engine.EnableRefunds.
+	RefundSats int
+}
+
+// jobRun is the engine side state of a job being run by a single DVM.
+type jobRun struct {
+	dvm             Dvmer
+	input           *Nip90Input
+	chanToDvm       chan *JobUpdate
+	paidSats        atomic.Int64
+	paidCreditsSats atomic.Int64
+
+	// charges counts the credit debits of the job, so every charge gets its own ref.
+	charges atomic.Int64
+
+	mu       sync.Mutex
+	done     chan struct{}
+	senders  sync.WaitGroup
+	cleanups []func()
+}
+
+func newJobRun(dvm Dvmer, input *Nip90Input) *jobRun {
+	return &jobRun{
+		dvm:       dvm,
+		input:     input,
+		chanToDvm: make(chan *JobUpdate),
+		done:      make(chan struct{}),
+	}
+}
+
+// notify delivers the update to the DVM without blocking the caller. Updates sent after the job finished are
+// dropped.
+func (r *jobRun) notify(update *JobUpdate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+
+	r.senders.Add(1)
+	go func() {
+		defer r.senders.Done()
+
+		select {
+		case r.chanToDvm <- update:
+		case <-r.done:
+		}
+	}()
+}
+
+// onFinish registers fn to be called when the job finishes, or calls it right away if it already did.
+func (r *jobRun) onFinish(fn func()) {
+	r.mu.Lock()
+	select {
+	case <-r.done:
+		r.mu.Unlock()
+		fn()
+		return
+	default:
+	}
+	r.cleanups = append(r.cleanups, fn)
+	r.mu.Unlock()
+}
+
+// finish marks the job as finished and runs its cleanups. chanToDvm is closed once every pending notify has given
+// up.
+func (r *jobRun) finish() {
+	r.mu.Lock()
+	close(r.done)
+	cleanups := r.cleanups
+	r.cleanups = nil
+	r.mu.Unlock()
+
+	for _, fn := range cleanups {
+		fn()
+	}
+
+	go func() {
+		r.senders.Wait()
+		close(r.chanToDvm)
+	}()
 }
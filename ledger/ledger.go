@@ -0,0 +1,80 @@
+// Package ledger keeps a record of every job a DVM took and what it was paid for it, so DVM activity can be
+// reconciled against the lightning wallet.
+package ledger
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrEntryNotFound = errors.New("ledger entry not found")
+)
+
+// Entry is the ledger record of a single job handled by a single DVM. Since every DVM registered for a kind gets
+// the same job request, entries are keyed by job ID and DVM public key.
+type Entry struct {
+	JobID               string    `json:"job_id"`
+	DvmPubkey           string    `json:"dvm_pubkey"`
+	Kind                int       `json:"kind"`
+	CustomerPubkey      string    `json:"customer_pubkey"`
+	Status              string    `json:"status"`
+	InvoiceHash         string    `json:"invoice_hash,omitempty"`
+	AmountRequestedSats int64     `json:"amount_requested_sats"`
+	FiatAmount          int64     `json:"fiat_amount,omitempty"`
+	FiatCurrency        string    `json:"fiat_currency,omitempty"`
+	AmountPaidSats      int64     `json:"amount_paid_sats"`
+	AmountRefundedSats  int64     `json:"amount_refunded_sats"`
+	PaidWithCredits     bool      `json:"paid_with_credits"`
+	RefundError         string    `json:"refund_error,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	InvoicedAt          time.Time `json:"invoiced_at"`
+	PaidAt              time.Time `json:"paid_at"`
+	RefundedAt          time.Time `json:"refunded_at"`
+}
+
+// Query selects ledger entries. Zero-valued fields match everything.
+type Query struct {
+	DvmPubkey      string
+	Kind           int
+	CustomerPubkey string
+	Since          time.Time
+	Until          time.Time
+	PaidOnly       bool
+}
+
+type Ledger interface {
+	// Update creates the entry for the job and DVM if it doesn't exist yet and applies fn to it atomically.
+	Update(ctx context.Context, jobID string, dvmPubkey string, fn func(e *Entry)) error
+
+	// Get returns a copy of the entry for the job and DVM, or ErrEntryNotFound.
+	Get(ctx context.Context, jobID string, dvmPubkey string) (*Entry, error)
+
+	// Entries returns copies of the entries matching the query, oldest first.
+	Entries(ctx context.Context, q Query) ([]*Entry, error)
+}
+
+func (q Query) matches(e *Entry) bool {
+	if q.DvmPubkey != "" && e.DvmPubkey != q.DvmPubkey {
+		return false
+	}
+	if q.Kind != 0 && e.Kind != q.Kind {
+		return false
+	}
+	if q.CustomerPubkey != "" && e.CustomerPubkey != q.CustomerPubkey {
+		return false
+	}
+	if !q.Since.IsZero() && e.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !e.CreatedAt.Before(q.Until) {
+		return false
+	}
+	if q.PaidOnly && e.AmountPaidSats == 0 {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,111 @@
+package ledger
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sebdeveloper6952/godvm/internal/filestore"
+)
+
+type memory struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*Entry
+}
+
+// NewMemory returns a Ledger that only lives in memory.
+func NewMemory() Ledger {
+	return &memory{
+		entries: make(map[string]*Entry),
+	}
+}
+
+// NewFile returns a Ledger kept in memory and saved as JSON to path after every update. Existing entries in path
+// are loaded first.
+func NewFile(path string) (Ledger, error) {
+	m := &memory{
+		path:    path,
+		entries: make(map[string]*Entry),
+	}
+
+	entries := make([]*Entry, 0)
+	if err := filestore.Load(path, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		m.entries[key(e.JobID, e.DvmPubkey)] = e
+	}
+
+	return m, nil
+}
+
+func (m *memory) Update(ctx context.Context, jobID string, dvmPubkey string, fn func(e *Entry)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	e, ok := m.entries[key(jobID, dvmPubkey)]
+	if !ok {
+		e = &Entry{
+			JobID:     jobID,
+			DvmPubkey: dvmPubkey,
+			CreatedAt: now,
+		}
+		m.entries[key(jobID, dvmPubkey)] = e
+	}
+
+	fn(e)
+	e.UpdatedAt = now
+
+	return m.save()
+}
+
+func (m *memory) Get(ctx context.Context, jobID string, dvmPubkey string) (*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key(jobID, dvmPubkey)]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	entry := *e
+
+	return &entry, nil
+}
+
+func (m *memory) Entries(ctx context.Context, q Query) ([]*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sorted(q), nil
+}
+
+func (m *memory) sorted(q Query) []*Entry {
+	entries := make([]*Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		if q.matches(e) {
+			entry := *e
+			entries = append(entries, &entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	return entries
+}
+
+func (m *memory) save() error {
+	if m.path == "" {
+		return nil
+	}
+
+	return filestore.Save(m.path, m.sorted(Query{}))
+}
+
+func key(jobID string, dvmPubkey string) string {
+	return jobID + ":" + dvmPubkey
+}
@@ -0,0 +1,76 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ledger.json")
+
+	l, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Get(ctx, "job", "a"); !errors.Is(err, ErrEntryNotFound) {
+		t.Fatalf("got %v, want ErrEntryNotFound", err)
+	}
+
+	update := func(jobID, dvm string, fn func(e *Entry)) {
+		t.Helper()
+		if err := l.Update(ctx, jobID, dvm, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update("job1", "a", func(e *Entry) { e.Kind = 5000 })
+	update("job1", "a", func(e *Entry) { e.AmountPaidSats += 10 })
+	time.Sleep(time.Millisecond)
+	update("job2", "a", func(e *Entry) { e.Kind = 5001 })
+	update("job1", "b", func(e *Entry) { e.Kind = 5000 })
+
+	entry, err := l.Get(ctx, "job1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Kind != 5000 || entry.AmountPaidSats != 10 || entry.CreatedAt.IsZero() {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	entry.AmountPaidSats = 100
+	if again, _ := l.Get(ctx, "job1", "a"); again.AmountPaidSats != 10 {
+		t.Error("Get returned the stored entry instead of a copy")
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  int
+	}{
+		{"all", Query{}, 3},
+		{"by dvm", Query{DvmPubkey: "a"}, 2},
+		{"by kind", Query{Kind: 5000}, 2},
+		{"paid only", Query{PaidOnly: true}, 1},
+		{"until excludes", Query{Until: entry.CreatedAt}, 0},
+	}
+	for _, tt := range tests {
+		entries, err := l.Entries(ctx, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != tt.want {
+			t.Errorf("%s: got %d entries, want %d", tt.name, len(entries), tt.want)
+		}
+	}
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := reopened.Entries(ctx, Query{}); len(entries) != 3 {
+		t.Errorf("reopened ledger has %d entries, want 3", len(entries))
+	}
+}
@@ -0,0 +1,184 @@
+package ledger
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type GroupBy string
+
+const (
+	GroupByDvm      GroupBy = "dvm"
+	GroupByKind     GroupBy = "kind"
+	GroupByCustomer GroupBy = "customer"
+)
+
+// Revenue aggregates the entries that share the same DVM, kind or customer.
+type Revenue struct {
+	GroupBy             GroupBy `json:"group_by"`
+	Key                 string  `json:"key"`
+	Jobs                int     `json:"jobs"`
+	PaidJobs            int     `json:"paid_jobs"`
+	AmountRequestedSats int64   `json:"amount_requested_sats"`
+	AmountPaidSats      int64   `json:"amount_paid_sats"`
+	AmountRefundedSats  int64   `json:"amount_refunded_sats"`
+}
+
+// RevenueBy groups the entries and returns one Revenue per group, highest paid amount first.
+func RevenueBy(entries []*Entry, groupBy GroupBy) []*Revenue {
+	byKey := make(map[string]*Revenue)
+	for _, e := range entries {
+		k := groupKey(e, groupBy)
+		r, ok := byKey[k]
+		if !ok {
+			r = &Revenue{
+				GroupBy: groupBy,
+				Key:     k,
+			}
+			byKey[k] = r
+		}
+
+		r.Jobs++
+		r.AmountRequestedSats += e.AmountRequestedSats
+		r.AmountPaidSats += e.AmountPaidSats
+		r.AmountRefundedSats += e.AmountRefundedSats
+		if e.AmountPaidSats > 0 {
+			r.PaidJobs++
+		}
+	}
+
+	revenue := make([]*Revenue, 0, len(byKey))
+	for _, r := range byKey {
+		revenue = append(revenue, r)
+	}
+
+	sort.Slice(revenue, func(i, j int) bool {
+		if revenue[i].AmountPaidSats != revenue[j].AmountPaidSats {
+			return revenue[i].AmountPaidSats > revenue[j].AmountPaidSats
+		}
+		return revenue[i].Key < revenue[j].Key
+	})
+
+	return revenue
+}
+
+// WriteRevenueCSV writes the revenue report as CSV with a header row.
+func WriteRevenueCSV(w io.Writer, revenue []*Revenue) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{
+		"group_by",
+		"key",
+		"jobs",
+		"paid_jobs",
+		"amount_requested_sats",
+		"amount_paid_sats",
+		"amount_refunded_sats",
+	}); err != nil {
+		return err
+	}
+
+	for _, r := range revenue {
+		if err := cw.Write([]string{
+			string(r.GroupBy),
+			r.Key,
+			strconv.Itoa(r.Jobs),
+			strconv.Itoa(r.PaidJobs),
+			strconv.FormatInt(r.AmountRequestedSats, 10),
+			strconv.FormatInt(r.AmountPaidSats, 10),
+			strconv.FormatInt(r.AmountRefundedSats, 10),
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// WriteRevenueJSON writes the revenue report as a JSON array.
+func WriteRevenueJSON(w io.Writer, revenue []*Revenue) error {
+	return json.NewEncoder(w).Encode(revenue)
+}
+
+// WriteEntriesCSV writes the raw ledger entries as CSV with a header row. Timestamps are RFC 3339, empty when unset.
+func WriteEntriesCSV(w io.Writer, entries []*Entry) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{
+		"job_id",
+		"dvm_pubkey",
+		"kind",
+		"customer_pubkey",
+		"status",
+		"invoice_hash",
+		"amount_requested_sats",
+		"fiat_amount",
+		"fiat_currency",
+		"amount_paid_sats",
+		"amount_refunded_sats",
+		"paid_with_credits",
+		"created_at",
+		"invoiced_at",
+		"paid_at",
+		"refunded_at",
+	}); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := cw.Write([]string{
+			e.JobID,
+			e.DvmPubkey,
+			strconv.Itoa(e.Kind),
+			e.CustomerPubkey,
+			e.Status,
+			e.InvoiceHash,
+			strconv.FormatInt(e.AmountRequestedSats, 10),
+			strconv.FormatInt(e.FiatAmount, 10),
+			e.FiatCurrency,
+			strconv.FormatInt(e.AmountPaidSats, 10),
+			strconv.FormatInt(e.AmountRefundedSats, 10),
+			strconv.FormatBool(e.PaidWithCredits),
+			formatTime(e.CreatedAt),
+			formatTime(e.InvoicedAt),
+			formatTime(e.PaidAt),
+			formatTime(e.RefundedAt),
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// WriteEntriesJSON writes the raw ledger entries as a JSON array.
+func WriteEntriesJSON(w io.Writer, entries []*Entry) error {
+	return json.NewEncoder(w).Encode(entries)
+}
+
+func groupKey(e *Entry, groupBy GroupBy) string {
+	switch groupBy {
+	case GroupByKind:
+		return strconv.Itoa(e.Kind)
+	case GroupByCustomer:
+		return e.CustomerPubkey
+	default:
+		return e.DvmPubkey
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
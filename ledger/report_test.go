@@ -0,0 +1,88 @@
+package ledger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRevenueBy(t *testing.T) {
+	entries := []*Entry{
+		{DvmPubkey: "a", Kind: 5000, CustomerPubkey: "x", AmountRequestedSats: 10, AmountPaidSats: 10},
+		{DvmPubkey: "a", Kind: 5001, CustomerPubkey: "y", AmountRequestedSats: 20},
+		{DvmPubkey: "b", Kind: 5000, CustomerPubkey: "x", AmountRequestedSats: 30, AmountPaidSats: 30,
+			AmountRefundedSats: 30},
+	}
+
+	tests := []struct {
+		groupBy GroupBy
+		want    []Revenue
+	}{
+		{
+			groupBy: GroupByDvm,
+			want: []Revenue{
+				{GroupBy: GroupByDvm, Key: "b", Jobs: 1, PaidJobs: 1, AmountRequestedSats: 30, AmountPaidSats: 30,
+					AmountRefundedSats: 30},
+				{GroupBy: GroupByDvm, Key: "a", Jobs: 2, PaidJobs: 1, AmountRequestedSats: 30, AmountPaidSats: 10},
+			},
+		},
+		{
+			groupBy: GroupByKind,
+			want: []Revenue{
+				{GroupBy: GroupByKind, Key: "5000", Jobs: 2, PaidJobs: 2, AmountRequestedSats: 40, AmountPaidSats: 40,
+					AmountRefundedSats: 30},
+				{GroupBy: GroupByKind, Key: "5001", Jobs: 1, AmountRequestedSats: 20},
+			},
+		},
+		{
+			groupBy: GroupByCustomer,
+			want: []Revenue{
+				{GroupBy: GroupByCustomer, Key: "x", Jobs: 2, PaidJobs: 2, AmountRequestedSats: 40, AmountPaidSats: 40,
+					AmountRefundedSats: 30},
+				{GroupBy: GroupByCustomer, Key: "y", Jobs: 1, AmountRequestedSats: 20},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.groupBy), func(t *testing.T) {
+			got := RevenueBy(entries, tt.groupBy)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d groups, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if *got[i] != tt.want[i] {
+					t.Errorf("group %d is %+v, want %+v", i, *got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteRevenueCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteRevenueCSV(&buf, []*Revenue{
+		{GroupBy: GroupByDvm, Key: "a", Jobs: 2, PaidJobs: 1, AmountRequestedSats: 30, AmountPaidSats: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "group_by,key,jobs,paid_jobs,amount_requested_sats,amount_paid_sats,amount_refunded_sats\n" +
+		"dvm,a,2,1,30,10,0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteEntriesCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteEntriesCSV(&buf, []*Entry{{JobID: "job", DvmPubkey: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "job_id,dvm_pubkey,") || !strings.HasPrefix(lines[1], "job,a,") {
+		t.Errorf("unexpected CSV %q", buf.String())
+	}
+}
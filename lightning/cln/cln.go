@@ -0,0 +1,462 @@
+// Package cln implements lightning.Service for Core Lightning over its clnrest plugin. Besides BOLT11 invoices it
+// supports BOLT12 offers, see lightning.Offerer, when the node runs with offers enabled.
+package cln
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+
+	"github.com/sebdeveloper6952/godvm/lightning"
+)
+
+const (
+	invoiceExpiry = 900
+
+	// waitTimeout bounds each waitanyinvoice call, so the stream notices a canceled context or a dead connection.
+	waitTimeout = 60
+
+	// payIndexPageSize is how many invoices are listed at once while looking for the last paid one.
+	payIndexPageSize = 100
+
+	codeUnknownCommand = -32601
+	codeInvoiceExpired = 903
+	codeWaitTimeout    = 904
+)
+
+// RPCError is returned when Core Lightning rejects a call.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("cln: %s (%d)", e.Message, e.Code)
+}
+
+type Option func(c *cln)
+
+// WithHTTPClient sets the client used for every request. Defaults to a client without timeout, since waiting for
+// invoices are long-lived requests. clnrest serves a self-signed certificate by default: the client must trust it.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *cln) {
+		c.client = client
+	}
+}
+
+type cln struct {
+	url    string
+	rune   string
+	client *http.Client
+
+	// invoicesPayIndex and offersPayIndex are the last pay index seen by the invoice and offer payment streams.
+	// A new subscription of each stream starts from its own, replaying the payments missed since the previous one
+	// ended.
+	invoicesPayIndex atomic.Uint64
+	offersPayIndex   atomic.Uint64
+}
+
+type invoice struct {
+	Label              string `json:"label"`
+	PaymentHash        string `json:"payment_hash"`
+	Status             string `json:"status"`
+	Bolt11             string `json:"bolt11"`
+	PayIndex           uint64 `json:"pay_index"`
+	AmountReceivedMsat int64  `json:"amount_received_msat"`
+	LocalOfferID       string `json:"local_offer_id"`
+	PayerNote          string `json:"invreq_payer_note"`
+}
+
+// New returns a service that calls the clnrest plugin at url, authenticated with rune.
+func New(url string, rune string, opts ...Option) (lightning.Service, error) {
+	c := &cln{
+		url:    url,
+		rune:   rune,
+		client: &http.Client{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+func (c *cln) AddInvoice(ctx context.Context, amountSats int64) (*lightning.Invoice, error) {
+	label, err := newLabel()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &struct {
+		PaymentHash string `json:"payment_hash"`
+		Bolt11      string `json:"bolt11"`
+	}{}
+	if err := c.call(ctx, "invoice", map[string]interface{}{
+		"amount_msat": amountSats * 1000,
+		"label":       label,
+		"description": "godvm job",
+		"expiry":      invoiceExpiry,
+	}, res); err != nil {
+		return nil, err
+	}
+
+	hash, err := lntypes.MakeHashFromStr(res.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lightning.Invoice{
+		Hash:   hash,
+		PayReq: res.Bolt11,
+	}, nil
+}
+
+// TrackInvoice waits for the invoice with waitinvoice, which returns as soon as it's paid or expired.
+func (c *cln) TrackInvoice(ctx context.Context, inv *lightning.Invoice) (chan *lightning.InvoiceUpdate, chan error) {
+	updates := make(chan *lightning.InvoiceUpdate)
+	errors := make(chan error)
+
+	go func() {
+		defer close(updates)
+		defer close(errors)
+
+		sendErr := func(err error) {
+			select {
+			case errors <- err:
+			case <-ctx.Done():
+			}
+		}
+
+		listed := &struct {
+			Invoices []*invoice `json:"invoices"`
+		}{}
+		if err := c.call(ctx, "listinvoices", map[string]interface{}{
+			"payment_hash": inv.Hash.String(),
+		}, listed); err != nil {
+			sendErr(err)
+			return
+		}
+		if len(listed.Invoices) == 0 {
+			sendErr(fmt.Errorf("cln: invoice %s not found", inv.Hash))
+			return
+		}
+
+		waited := &invoice{}
+		err := c.call(ctx, "waitinvoice", map[string]interface{}{
+			"label": listed.Invoices[0].Label,
+		}, waited)
+		if isCode(err, codeInvoiceExpired) {
+			sendErr(lightning.ErrInvoiceExpired)
+			return
+		}
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		if waited.Status == "paid" {
+			select {
+			case updates <- &lightning.InvoiceUpdate{Settled: true}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return updates, errors
+}
+
+// SubscribeInvoices streams the settlements of every invoice of the node. Payments received since the previous
+// subscription ended are replayed first.
+func (c *cln) SubscribeInvoices(ctx context.Context) (chan *lightning.InvoiceUpdate, chan error) {
+	updates := make(chan *lightning.InvoiceUpdate)
+	errors := make(chan error)
+
+	go func() {
+		defer close(updates)
+		defer close(errors)
+
+		err := c.waitAnyInvoice(ctx, &c.invoicesPayIndex, func(inv *invoice) bool {
+			hash, err := lntypes.MakeHashFromStr(inv.PaymentHash)
+			if err != nil {
+				return true
+			}
+
+			select {
+			case updates <- &lightning.InvoiceUpdate{Hash: hash, Settled: true}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil {
+			select {
+			case errors <- err:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return updates, errors
+}
+
+func (c *cln) PayInvoice(ctx context.Context, payReq string) (*lightning.Payment, error) {
+	decoded, err := lightning.DecodePayReq(payReq)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]interface{}{
+		"bolt11": payReq,
+	}
+	if decoded.MilliSat != nil {
+		params["maxfee"] = lightning.MaxFeeSats(int64(*decoded.MilliSat)/1000) * 1000
+	}
+
+	res := &struct {
+		PaymentHash     string `json:"payment_hash"`
+		PaymentPreimage string `json:"payment_preimage"`
+		AmountMsat      int64  `json:"amount_msat"`
+		AmountSentMsat  int64  `json:"amount_sent_msat"`
+	}{}
+	if err := c.call(ctx, "pay", params, res); err != nil {
+		return nil, err
+	}
+
+	hash, err := lntypes.MakeHashFromStr(res.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	preimage, err := lntypes.MakePreimageFromStr(res.PaymentPreimage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lightning.Payment{
+		Hash:       hash,
+		Preimage:   preimage,
+		AmountSats: res.AmountMsat / 1000,
+		FeeSats:    (res.AmountSentMsat - res.AmountMsat) / 1000,
+	}, nil
+}
+
+// CreateOffer creates an offer for any amount. It returns lightning.ErrOffersNotSupported when the node doesn't have
+// offers enabled.
+func (c *cln) CreateOffer(ctx context.Context, description string) (*lightning.Offer, error) {
+	res := &struct {
+		OfferID string `json:"offer_id"`
+		Bolt12  string `json:"bolt12"`
+	}{}
+	err := c.call(ctx, "offer", map[string]interface{}{
+		"amount":      "any",
+		"description": description,
+	}, res)
+	if isCode(err, codeUnknownCommand) {
+		return nil, lightning.ErrOffersNotSupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &lightning.Offer{
+		ID:     res.OfferID,
+		Bolt12: res.Bolt12,
+	}, nil
+}
+
+func (c *cln) SubscribeOfferPayments(ctx context.Context) (chan *lightning.OfferPayment, chan error) {
+	payments := make(chan *lightning.OfferPayment)
+	errors := make(chan error)
+
+	go func() {
+		defer close(payments)
+		defer close(errors)
+
+		err := c.waitAnyInvoice(ctx, &c.offersPayIndex, func(inv *invoice) bool {
+			if inv.LocalOfferID == "" {
+				return true
+			}
+
+			hash, err := lntypes.MakeHashFromStr(inv.PaymentHash)
+			if err != nil {
+				return true
+			}
+
+			select {
+			case payments <- &lightning.OfferPayment{
+				OfferID:    inv.LocalOfferID,
+				Hash:       hash,
+				AmountSats: inv.AmountReceivedMsat / 1000,
+				PayerNote:  inv.PayerNote,
+			}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil {
+			select {
+			case errors <- err:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return payments, errors
+}
+
+// waitAnyInvoice calls fn with every paid invoice, in pay order, until fn returns false, ctx is done or a call fails.
+// It resumes from the pay index of the stream, and keeps it up to date.
+func (c *cln) waitAnyInvoice(ctx context.Context, index *atomic.Uint64, fn func(inv *invoice) bool) error {
+	payIndex := index.Load()
+	if payIndex == 0 {
+		// waitanyinvoice without lastpay_index starts from the first invoice ever paid, skip them.
+		latest, err := c.latestPayIndex(ctx)
+		if err != nil {
+			return err
+		}
+		payIndex = latest
+	}
+
+	for {
+		params := map[string]interface{}{
+			"timeout": waitTimeout,
+		}
+		if payIndex > 0 {
+			params["lastpay_index"] = payIndex
+		}
+
+		inv := &invoice{}
+		err := c.call(ctx, "waitanyinvoice", params, inv)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if isCode(err, codeWaitTimeout) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		payIndex = inv.PayIndex
+		if inv.Status != "paid" {
+			index.Store(payIndex)
+			continue
+		}
+		if !fn(inv) {
+			return nil
+		}
+		index.Store(payIndex)
+	}
+}
+
+// latestPayIndex returns the pay index of the last paid invoice. Invoices are listed by the order they were last
+// updated, a page at a time from the most recent, so the whole invoice history is only read when nothing was paid.
+func (c *cln) latestPayIndex(ctx context.Context) (uint64, error) {
+	current := &struct {
+		Updated uint64 `json:"updated"`
+	}{}
+	err := c.call(ctx, "wait", map[string]interface{}{
+		"subsystem": "invoices",
+		"indexname": "updated",
+		"nextvalue": 0,
+	}, current)
+	if isCode(err, codeUnknownCommand) {
+		// nodes without invoice indexes can only list every invoice.
+		return c.latestPayIndexIn(ctx, map[string]interface{}{})
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for end := current.Updated; end > 0; {
+		start := uint64(1)
+		if end > payIndexPageSize {
+			start = end - payIndexPageSize + 1
+		}
+
+		latest, err := c.latestPayIndexIn(ctx, map[string]interface{}{
+			"index": "updated",
+			"start": start,
+			"limit": end - start + 1,
+		})
+		if err != nil || latest > 0 {
+			return latest, err
+		}
+		end = start - 1
+	}
+
+	return 0, nil
+}
+
+func (c *cln) latestPayIndexIn(ctx context.Context, params map[string]interface{}) (uint64, error) {
+	listed := &struct {
+		Invoices []*invoice `json:"invoices"`
+	}{}
+	if err := c.call(ctx, "listinvoices", params, listed); err != nil {
+		return 0, err
+	}
+
+	var latest uint64
+	for _, inv := range listed.Invoices {
+		latest = max(latest, inv.PayIndex)
+	}
+
+	return latest, nil
+}
+
+func (c *cln) call(ctx context.Context, method string, params map[string]interface{}, target interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/v1/"+method, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Rune", c.rune)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		resBody, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		rpcErr := &RPCError{}
+		if err := json.Unmarshal(resBody, rpcErr); err != nil || rpcErr.Message == "" {
+			return &RPCError{Code: res.StatusCode, Message: string(resBody)}
+		}
+		return rpcErr
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
+
+func isCode(err error, code int) bool {
+	rpcErr := &RPCError{}
+
+	return errors.As(err, &rpcErr) && rpcErr.Code == code
+}
+
+func newLabel() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("godvm-%d-%s", time.Now().Unix(), hex.EncodeToString(b)), nil
+}
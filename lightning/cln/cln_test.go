@@ -0,0 +1,517 @@
+package cln
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+
+	"github.com/sebdeveloper6952/godvm/lightning"
+	"github.com/sebdeveloper6952/godvm/lightning/fake"
+)
+
+const testRune = "rune"
+
+type nodeInvoice struct {
+	invoice
+	updated uint64
+}
+
+// node is a minimal clnrest server, issuing its invoices with a fake backend. Waiting calls return code 904 when
+// nothing happened for a while, as waitanyinvoice does once its timeout elapses.
+type node struct {
+	*httptest.Server
+
+	ln *fake.Fake
+
+	// legacy nodes have neither invoice indexes nor offers.
+	legacy bool
+
+	mu       sync.Mutex
+	invoices []*nodeInvoice
+	payIndex uint64
+	updated  uint64
+	changed  chan struct{}
+
+	// waiters counts the waitanyinvoice calls, so tests know when a stream skipped the past payments.
+	waiters int
+}
+
+func newNode(t *testing.T, legacy bool) *node {
+	t.Helper()
+
+	ln, err := fake.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &node{
+		ln:      ln,
+		legacy:  legacy,
+		changed: make(chan struct{}),
+	}
+	n.Server = httptest.NewServer(http.HandlerFunc(n.serveHTTP))
+	t.Cleanup(n.Close)
+
+	return n
+}
+
+func (n *node) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Rune") != testRune {
+		writeError(w, http.StatusUnauthorized, 1501, "not authorized")
+		return
+	}
+
+	params := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, -32602, err.Error())
+		return
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if n.legacy && (method == "wait" || method == "offer") {
+		writeError(w, http.StatusInternalServerError, codeUnknownCommand, "Unknown command")
+		return
+	}
+
+	switch method {
+	case "invoice":
+		invoice, err := n.ln.AddInvoice(r.Context(), int64(params["amount_msat"].(float64))/1000)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, -1, err.Error())
+			return
+		}
+		n.add(params["label"].(string), invoice, "")
+		writeJSON(w, map[string]string{"payment_hash": invoice.Hash.String(), "bolt11": invoice.PayReq})
+
+	case "listinvoices":
+		writeJSON(w, map[string]interface{}{"invoices": n.list(params)})
+
+	case "waitinvoice":
+		n.waitInvoice(w, r, params["label"].(string))
+
+	case "waitanyinvoice":
+		lastPayIndex, _ := params["lastpay_index"].(float64)
+		n.waitAnyInvoice(w, r, uint64(lastPayIndex))
+
+	case "wait":
+		n.mu.Lock()
+		updated := n.updated
+		n.mu.Unlock()
+		writeJSON(w, map[string]interface{}{"subsystem": "invoices", "updated": updated})
+
+	case "pay":
+		preimage := lntypes.Preimage{1}
+		writeJSON(w, map[string]interface{}{
+			"payment_hash":     preimage.Hash().String(),
+			"payment_preimage": preimage.String(),
+			"amount_msat":      42_000,
+			"amount_sent_msat": 44_000,
+		})
+
+	case "offer":
+		writeJSON(w, map[string]string{"offer_id": "offer", "bolt12": "lno1offer"})
+
+	default:
+		writeError(w, http.StatusInternalServerError, codeUnknownCommand, "Unknown command")
+	}
+}
+
+func (n *node) add(label string, inv *lightning.Invoice, offerID string) *nodeInvoice {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.updated++
+	added := &nodeInvoice{
+		invoice: invoice{
+			Label:        label,
+			PaymentHash:  inv.Hash.String(),
+			Status:       "unpaid",
+			Bolt11:       inv.PayReq,
+			LocalOfferID: offerID,
+		},
+		updated: n.updated,
+	}
+	n.invoices = append(n.invoices, added)
+	n.changeLocked()
+
+	return added
+}
+
+// finish marks the invoice with the given hash as paid or expired.
+func (n *node) finish(t *testing.T, hash lntypes.Hash, status string) {
+	t.Helper()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, inv := range n.invoices {
+		if inv.PaymentHash != hash.String() {
+			continue
+		}
+
+		inv.Status = status
+		if status == "paid" {
+			n.payIndex++
+			inv.PayIndex = n.payIndex
+			inv.AmountReceivedMsat = 10_000
+		}
+		n.updated++
+		inv.updated = n.updated
+		n.changeLocked()
+		return
+	}
+
+	t.Fatalf("invoice %s not found", hash)
+}
+
+// changeLocked must be called with n.mu held.
+func (n *node) changeLocked() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+func (n *node) list(params map[string]interface{}) []invoice {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	hash, _ := params["payment_hash"].(string)
+	start, _ := params["start"].(float64)
+	limit, _ := params["limit"].(float64)
+
+	listed := make([]invoice, 0)
+	for _, inv := range n.invoices {
+		if hash != "" && inv.PaymentHash != hash {
+			continue
+		}
+		if params["index"] == "updated" && (inv.updated < uint64(start) || inv.updated >= uint64(start+limit)) {
+			continue
+		}
+		listed = append(listed, inv.invoice)
+	}
+
+	return listed
+}
+
+func (n *node) waitInvoice(w http.ResponseWriter, r *http.Request, label string) {
+	for {
+		n.mu.Lock()
+		changed := n.changed
+		var found *invoice
+		for _, inv := range n.invoices {
+			if inv.Label == label {
+				copied := inv.invoice
+				found = &copied
+			}
+		}
+		n.mu.Unlock()
+
+		switch {
+		case found == nil:
+			writeError(w, http.StatusInternalServerError, -1, "Unknown invoice")
+			return
+		case found.Status == "paid":
+			writeJSON(w, found)
+			return
+		case found.Status == "expired":
+			writeError(w, http.StatusInternalServerError, codeInvoiceExpired, "Invoice expired")
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (n *node) waitAnyInvoice(w http.ResponseWriter, r *http.Request, lastPayIndex uint64) {
+	n.mu.Lock()
+	n.waiters++
+	n.mu.Unlock()
+
+	for {
+		n.mu.Lock()
+		changed := n.changed
+		var next *invoice
+		for _, inv := range n.invoices {
+			if inv.PayIndex > lastPayIndex && (next == nil || inv.PayIndex < next.PayIndex) {
+				copied := inv.invoice
+				next = &copied
+			}
+		}
+		n.mu.Unlock()
+
+		if next != nil {
+			writeJSON(w, next)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(50 * time.Millisecond):
+			writeError(w, http.StatusInternalServerError, codeWaitTimeout, "Timed out")
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// waitForStream waits until a stream called waitanyinvoice.
+func (n *node) waitForStream(t *testing.T) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		n.mu.Lock()
+		waiters := n.waiters
+		n.mu.Unlock()
+		if waiters > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("timed out waiting for a stream")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code int, message string) {
+	w.WriteHeader(status)
+	writeJSON(w, &RPCError{Code: code, Message: message})
+}
+
+func addInvoice(t *testing.T, svc lightning.Service) *lightning.Invoice {
+	t.Helper()
+
+	invoice, err := svc.AddInvoice(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return invoice
+}
+
+func TestAddInvoice(t *testing.T) {
+	n := newNode(t, false)
+	svc, _ := New(n.URL, testRune)
+
+	invoice := addInvoice(t, svc)
+
+	decoded, err := lightning.DecodePayReq(invoice.PayReq)
+	if err != nil || decoded.MilliSat == nil || *decoded.MilliSat != 10_000 {
+		t.Errorf("got bolt11 %+v, %v, want 10 sats", decoded, err)
+	}
+	if listed := n.list(map[string]interface{}{}); len(listed) != 1 || listed[0].PaymentHash != invoice.Hash.String() {
+		t.Errorf("got invoices %+v, want the one added", listed)
+	}
+}
+
+func TestWrongRune(t *testing.T) {
+	n := newNode(t, false)
+	svc, _ := New(n.URL, "wrong")
+
+	_, err := svc.AddInvoice(context.Background(), 10)
+	if !isCode(err, 1501) {
+		t.Fatalf("got error %v, want code 1501", err)
+	}
+}
+
+func TestTrackInvoice(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		wantErr error
+	}{
+		{"paid", "paid", nil},
+		{"expired", "expired", lightning.ErrInvoiceExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			n := newNode(t, false)
+			svc, _ := New(n.URL, testRune)
+			invoice := addInvoice(t, svc)
+
+			updates, errs := svc.TrackInvoice(ctx, invoice)
+			n.finish(t, invoice.Hash, tt.status)
+
+			select {
+			case update := <-updates:
+				if tt.wantErr != nil || !update.Settled {
+					t.Errorf("got update %+v, want error %v", update, tt.wantErr)
+				}
+			case err := <-errs:
+				if tt.wantErr == nil || !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the invoice")
+			}
+		})
+	}
+}
+
+func TestTrackUnknownInvoice(t *testing.T) {
+	n := newNode(t, false)
+	svc, _ := New(n.URL, testRune)
+
+	updates, errs := svc.TrackInvoice(context.Background(), &lightning.Invoice{})
+	select {
+	case update := <-updates:
+		t.Fatalf("got update %+v for an unknown invoice", update)
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("got no error for an unknown invoice")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an error")
+	}
+}
+
+func nextSettled(t *testing.T, updates chan *lightning.InvoiceUpdate, errs chan error) lntypes.Hash {
+	t.Helper()
+
+	select {
+	case update := <-updates:
+		if !update.Settled {
+			t.Fatalf("got update %+v, want a settlement", update)
+		}
+		return update.Hash
+	case err := <-errs:
+		t.Fatalf("got error %v, want a settlement", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a settlement")
+	}
+
+	return lntypes.Hash{}
+}
+
+func TestSubscribeInvoices(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		name := "indexed"
+		if legacy {
+			name = "legacy"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			n := newNode(t, legacy)
+			svc, _ := New(n.URL, testRune)
+			subscriber := svc.(lightning.InvoiceSubscriber)
+
+			// invoices paid before the first subscription are not streamed.
+			for i := 0; i < payIndexPageSize+1; i++ {
+				addInvoice(t, svc)
+			}
+			old := addInvoice(t, svc)
+			n.finish(t, old.Hash, "paid")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			updates, errs := subscriber.SubscribeInvoices(ctx)
+			n.waitForStream(t)
+			paid := addInvoice(t, svc)
+			n.finish(t, paid.Hash, "paid")
+			if hash := nextSettled(t, updates, errs); hash != paid.Hash {
+				t.Fatalf("got settlement of %s, want %s", hash, paid.Hash)
+			}
+			cancel()
+
+			// invoices paid between two subscriptions are replayed.
+			missed := addInvoice(t, svc)
+			n.finish(t, missed.Hash, "paid")
+
+			ctx, cancel = context.WithCancel(context.Background())
+			defer cancel()
+			updates, errs = subscriber.SubscribeInvoices(ctx)
+			if hash := nextSettled(t, updates, errs); hash != missed.Hash {
+				t.Fatalf("got settlement of %s, want %s", hash, missed.Hash)
+			}
+		})
+	}
+}
+
+func TestPayInvoice(t *testing.T) {
+	n := newNode(t, false)
+	svc, _ := New(n.URL, testRune)
+
+	invoice, err := n.ln.AddInvoice(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := svc.(lightning.Payer).PayInvoice(context.Background(), invoice.PayReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Preimage != (lntypes.Preimage{1}) || payment.AmountSats != 42 || payment.FeeSats != 2 {
+		t.Errorf("got payment %+v, want 42 sats with 2 fee sats", payment)
+	}
+}
+
+func TestCreateOffer(t *testing.T) {
+	n := newNode(t, false)
+	svc, _ := New(n.URL, testRune)
+
+	offer, err := svc.(lightning.Offerer).CreateOffer(context.Background(), "godvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.ID != "offer" || offer.Bolt12 != "lno1offer" {
+		t.Errorf("got offer %+v", offer)
+	}
+
+	legacy := newNode(t, true)
+	svc, _ = New(legacy.URL, testRune)
+	_, err = svc.(lightning.Offerer).CreateOffer(context.Background(), "godvm")
+	if !errors.Is(err, lightning.ErrOffersNotSupported) {
+		t.Errorf("got error %v, want %v", err, lightning.ErrOffersNotSupported)
+	}
+}
+
+func TestSubscribeOfferPayments(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := newNode(t, false)
+	svc, _ := New(n.URL, testRune)
+
+	payments, errs := svc.(lightning.Offerer).SubscribeOfferPayments(ctx)
+	n.waitForStream(t)
+
+	// only the invoices of offers are offer payments.
+	bolt11 := addInvoice(t, svc)
+	n.finish(t, bolt11.Hash, "paid")
+
+	requested, err := n.ln.AddInvoice(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.add("offer-invoice", requested, "offer")
+	n.finish(t, requested.Hash, "paid")
+
+	select {
+	case payment := <-payments:
+		if payment.OfferID != "offer" || payment.Hash != requested.Hash || payment.AmountSats != 10 {
+			t.Errorf("got payment %+v, want 10 sats to the offer", payment)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the offer payment")
+	}
+}
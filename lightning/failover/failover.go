@@ -0,0 +1,235 @@
+// Package failover implements a lightning.Service that issues invoices with a list of backends in order of
+// preference, moving to the next one when a backend fails to create an invoice.
+package failover
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+
+	"github.com/sebdeveloper6952/godvm/lightning"
+)
+
+type failover struct {
+	services []lightning.Service
+
+	mu      sync.Mutex
+	issuers map[lntypes.Hash]lightning.Service
+}
+
+// subscriber is a failover whose backends all implement lightning.InvoiceSubscriber.
+type subscriber struct {
+	*failover
+}
+
+// New returns a lightning.Service that creates invoices with primary, or with the first of the fallbacks that
+// succeeds when it fails. Every invoice is tracked on the backend that issued it. When every backend implements
+// lightning.InvoiceSubscriber, so does the returned service, otherwise invoices are tracked one by one.
+func New(primary lightning.Service, fallbacks ...lightning.Service) lightning.Service {
+	f := &failover{
+		services: append([]lightning.Service{primary}, fallbacks...),
+		issuers:  make(map[lntypes.Hash]lightning.Service),
+	}
+
+	for _, svc := range f.services {
+		if _, ok := svc.(lightning.InvoiceSubscriber); !ok {
+			return f
+		}
+	}
+
+	return &subscriber{failover: f}
+}
+
+func (f *failover) AddInvoice(ctx context.Context, amountSats int64) (*lightning.Invoice, error) {
+	errs := make([]error, 0, len(f.services))
+
+	for _, svc := range f.services {
+		invoice, err := svc.AddInvoice(ctx, amountSats)
+		if err != nil {
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		f.mu.Lock()
+		f.issuers[invoice.Hash] = svc
+		f.mu.Unlock()
+
+		return invoice, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// TrackInvoice tracks the invoice on the backend that issued it. Invoices this service didn't issue, e.g. before a
+// restart, are tracked on every backend: the first settlement is reported, and an error only once every backend
+// failed.
+func (f *failover) TrackInvoice(ctx context.Context, invoice *lightning.Invoice) (chan *lightning.InvoiceUpdate, chan error) {
+	f.mu.Lock()
+	svc, ok := f.issuers[invoice.Hash]
+	f.mu.Unlock()
+
+	services := []lightning.Service{svc}
+	if !ok {
+		services = f.services
+	}
+
+	updates := make(chan *lightning.InvoiceUpdate)
+	errs := make(chan error)
+
+	go func() {
+		defer close(updates)
+		defer close(errs)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		merged := make(chan *lightning.InvoiceUpdate)
+		ended := make(chan error, len(services))
+		for _, svc := range services {
+			u, errs := svc.TrackInvoice(ctx, invoice)
+			go forward(ctx, u, errs, merged, ended)
+		}
+
+		failures := make([]error, 0, len(services))
+		for endedCount := 0; endedCount < len(services); {
+			select {
+			case update := <-merged:
+				if update.Settled {
+					f.forget(invoice.Hash)
+				}
+				select {
+				case updates <- update:
+				case <-ctx.Done():
+					return
+				}
+				if update.Settled {
+					return
+				}
+			case err := <-ended:
+				endedCount++
+				if err != nil {
+					failures = append(failures, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		f.forget(invoice.Hash)
+		if len(failures) > 0 {
+			select {
+			case errs <- errors.Join(failures...):
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return updates, errs
+}
+
+// forward sends the updates of a backend stream to merged until the stream ends, and then sends the error it ended
+// with, if any, to ended.
+func forward(
+	ctx context.Context,
+	u chan *lightning.InvoiceUpdate,
+	errs chan error,
+	merged chan<- *lightning.InvoiceUpdate,
+	ended chan<- error,
+) {
+	for {
+		select {
+		case update, ok := <-u:
+			if !ok {
+				ended <- nil
+				return
+			}
+			select {
+			case merged <- update:
+			case <-ctx.Done():
+				ended <- nil
+				return
+			}
+		case err, ok := <-errs:
+			if ok {
+				ended <- err
+			} else {
+				ended <- nil
+			}
+			return
+		case <-ctx.Done():
+			ended <- nil
+			return
+		}
+	}
+}
+
+// SubscribeInvoices merges the invoice streams of every backend. It ends as soon as one of them ends, so the invoices
+// still waited on are tracked one by one until the next subscription.
+func (s *subscriber) SubscribeInvoices(ctx context.Context) (chan *lightning.InvoiceUpdate, chan error) {
+	updates := make(chan *lightning.InvoiceUpdate)
+	errs := make(chan error)
+
+	ctx, cancel := context.WithCancel(ctx)
+	merged := make(chan *lightning.InvoiceUpdate)
+	ended := make(chan error, len(s.services))
+	for _, svc := range s.services {
+		u, errs := svc.(lightning.InvoiceSubscriber).SubscribeInvoices(ctx)
+		go forward(ctx, u, errs, merged, ended)
+	}
+
+	go func() {
+		defer close(updates)
+		defer close(errs)
+		defer cancel()
+
+		for {
+			select {
+			case update := <-merged:
+				if update.Settled || update.Err != nil {
+					s.forget(update.Hash)
+				}
+				select {
+				case updates <- update:
+				case <-ctx.Done():
+					return
+				}
+			case err := <-ended:
+				if err != nil {
+					select {
+					case errs <- err:
+					case <-ctx.Done():
+					}
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return updates, errs
+}
+
+// PayInvoice pays with the first backend that can pay invoices. It never retries on another backend, since a
+// payment that failed ambiguously may still complete.
+func (f *failover) PayInvoice(ctx context.Context, payReq string) (*lightning.Payment, error) {
+	for _, svc := range f.services {
+		if payer, ok := svc.(lightning.Payer); ok {
+			return payer.PayInvoice(ctx, payReq)
+		}
+	}
+
+	return nil, lightning.ErrPaymentsNotSupported
+}
+
+func (f *failover) forget(hash lntypes.Hash) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.issuers, hash)
+}
@@ -0,0 +1,124 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sebdeveloper6952/godvm/lightning"
+	"github.com/sebdeveloper6952/godvm/lightning/fake"
+)
+
+func newFakes(t *testing.T) (*fake.Fake, *fake.Fake) {
+	primary, err := fake.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback, err := fake.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return primary, fallback
+}
+
+func waitSettled(t *testing.T, updates chan *lightning.InvoiceUpdate, errs chan error) *lightning.InvoiceUpdate {
+	t.Helper()
+
+	select {
+	case update := <-updates:
+		if !update.Settled {
+			t.Fatalf("got update %+v, want a settlement", update)
+		}
+		return update
+	case err := <-errs:
+		t.Fatalf("got error %v, want a settlement", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a settlement")
+	}
+
+	return nil
+}
+
+func TestAddInvoiceFallsBack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, fallback := newFakes(t)
+	svc := New(primary, fallback)
+
+	primary.FailNextAddInvoice(errors.New("primary down"))
+	invoice, err := svc.AddInvoice(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.Invoices()) != 0 || len(fallback.Invoices()) != 1 {
+		t.Fatal("invoice not issued by the fallback")
+	}
+
+	updates, errs := svc.TrackInvoice(ctx, invoice)
+	if err := fallback.Settle(invoice.Hash); err != nil {
+		t.Fatal(err)
+	}
+	waitSettled(t, updates, errs)
+}
+
+func TestAddInvoiceFails(t *testing.T) {
+	primary, fallback := newFakes(t)
+	primary.FailNextAddInvoice(errors.New("primary down"))
+	fallback.FailNextAddInvoice(errors.New("fallback down"))
+
+	if _, err := New(primary, fallback).AddInvoice(context.Background(), 10); err == nil {
+		t.Fatal("got an invoice, want an error")
+	}
+}
+
+func TestTrackInvoiceOfUnknownIssuer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, fallback := newFakes(t)
+	invoice, err := fallback.AddInvoice(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new service, as after a restart, doesn't know the fallback issued the invoice.
+	updates, errs := New(primary, fallback).TrackInvoice(ctx, invoice)
+	if err := fallback.Settle(invoice.Hash); err != nil {
+		t.Fatal(err)
+	}
+	waitSettled(t, updates, errs)
+}
+
+func TestSubscribeInvoices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	primary, fallback := newFakes(t)
+	svc := New(primary, fallback)
+
+	if _, ok := New(primary, struct{ lightning.Service }{fallback}).(lightning.InvoiceSubscriber); ok {
+		t.Fatal("subscriber with a backend that can't stream its invoices")
+	}
+	subscriber, ok := svc.(lightning.InvoiceSubscriber)
+	if !ok {
+		t.Fatal("not a subscriber with backends that all stream their invoices")
+	}
+
+	updates, errs := subscriber.SubscribeInvoices(ctx)
+
+	for _, backend := range []*fake.Fake{primary, fallback} {
+		invoice, err := backend.AddInvoice(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Settle(invoice.Hash); err != nil {
+			t.Fatal(err)
+		}
+		if update := waitSettled(t, updates, errs); update.Hash != invoice.Hash {
+			t.Fatalf("got settlement of %s, want %s", update.Hash, invoice.Hash)
+		}
+	}
+}
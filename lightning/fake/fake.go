@@ -0,0 +1,404 @@
+// Package fake implements an in-memory lightning.Service. Invoices are real bolt11 strings signed by a throwaway
+// node key, but no payment ever happens: tests settle, expire or fail them by hand, or let them settle on their own
+// after a delay.
+package fake
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+
+	"github.com/sebdeveloper6952/godvm/lightning"
+)
+
+var (
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	ErrInvoiceNotOpen  = errors.New("invoice is not open")
+)
+
+type State int
+
+const (
+	StateOpen    State = 1
+	StateSettled State = 2
+	StateExpired State = 3
+	StateFailed  State = 4
+)
+
+type Option func(f *Fake)
+
+// WithAutoSettle makes every new invoice settle by itself after the given delay.
+func WithAutoSettle(delay time.Duration) Option {
+	return func(f *Fake) {
+		f.autoSettle = delay
+	}
+}
+
+// WithNetwork sets the network the bolt11 strings are encoded for. Defaults to regtest.
+func WithNetwork(net *chaincfg.Params) Option {
+	return func(f *Fake) {
+		f.net = net
+	}
+}
+
+// WithExpiry sets the expiry encoded in new invoices. Defaults to 15 minutes.
+func WithExpiry(expiry time.Duration) Option {
+	return func(f *Fake) {
+		f.expiry = expiry
+	}
+}
+
+type invoice struct {
+	invoice    *lightning.Invoice
+	amountSats int64
+	state      State
+	err        error
+	done       chan struct{}
+}
+
+// Payment is an outgoing payment made through PayInvoice.
+type Payment struct {
+	PayReq     string
+	AmountSats int64
+}
+
+type Fake struct {
+	mu            sync.Mutex
+	nodeKey       *btcec.PrivateKey
+	net           *chaincfg.Params
+	expiry        time.Duration
+	autoSettle    time.Duration
+	addInvoiceErr error
+	payErr        error
+	invoices      map[lntypes.Hash]*invoice
+	order         []lntypes.Hash
+	payments      []*Payment
+	subscribers   map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	ctx     context.Context
+	updates chan *lightning.InvoiceUpdate
+}
+
+func New(opts ...Option) (*Fake, error) {
+	nodeKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Fake{
+		nodeKey:     nodeKey,
+		net:         &chaincfg.RegressionNetParams,
+		expiry:      15 * time.Minute,
+		invoices:    make(map[lntypes.Hash]*invoice),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f, nil
+}
+
+func (f *Fake) AddInvoice(ctx context.Context, amountSats int64) (*lightning.Invoice, error) {
+	f.mu.Lock()
+	if err := f.addInvoiceErr; err != nil {
+		f.addInvoiceErr = nil
+		f.mu.Unlock()
+		return nil, err
+	}
+	f.mu.Unlock()
+
+	preimage := lntypes.Preimage{}
+	if _, err := rand.Read(preimage[:]); err != nil {
+		return nil, err
+	}
+	hash := preimage.Hash()
+
+	paymentAddr := [32]byte{}
+	if _, err := rand.Read(paymentAddr[:]); err != nil {
+		return nil, err
+	}
+
+	payReq, err := zpay32.NewInvoice(
+		f.net,
+		hash,
+		time.Now(),
+		zpay32.Amount(lnwire.MilliSatoshi(amountSats*1000)),
+		zpay32.Description("godvm fake invoice"),
+		zpay32.Expiry(f.expiry),
+		zpay32.PaymentAddr(paymentAddr),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := payReq.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return ecdsa.SignCompact(f.nodeKey, chainhash.HashB(msg), true)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &invoice{
+		invoice: &lightning.Invoice{
+			Hash:   hash,
+			PayReq: encoded,
+		},
+		amountSats: amountSats,
+		state:      StateOpen,
+		done:       make(chan struct{}),
+	}
+
+	f.mu.Lock()
+	f.invoices[hash] = inv
+	f.order = append(f.order, hash)
+	autoSettle := f.autoSettle
+	f.mu.Unlock()
+
+	if autoSettle > 0 {
+		time.AfterFunc(autoSettle, func() {
+			_ = f.Settle(hash)
+		})
+	}
+
+	return inv.invoice, nil
+}
+
+func (f *Fake) TrackInvoice(ctx context.Context, invoice *lightning.Invoice) (chan *lightning.InvoiceUpdate, chan error) {
+	updates := make(chan *lightning.InvoiceUpdate)
+	errors := make(chan error)
+
+	go func() {
+		defer close(updates)
+		defer close(errors)
+
+		f.mu.Lock()
+		inv, ok := f.invoices[invoice.Hash]
+		f.mu.Unlock()
+		if !ok {
+			select {
+			case errors <- ErrInvoiceNotFound:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		select {
+		case <-inv.done:
+		case <-ctx.Done():
+			return
+		}
+
+		f.mu.Lock()
+		state, err := inv.state, inv.err
+		f.mu.Unlock()
+
+		if state == StateSettled {
+			select {
+			case updates <- &lightning.InvoiceUpdate{Settled: true}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		select {
+		case errors <- err:
+		case <-ctx.Done():
+		}
+	}()
+
+	return updates, errors
+}
+
+// PayInvoice pretends to pay the invoice and records it, see Payments. Paying an invoice created by this same
+// service settles it.
+func (f *Fake) PayInvoice(ctx context.Context, payReq string) (*lightning.Payment, error) {
+	f.mu.Lock()
+	if err := f.payErr; err != nil {
+		f.payErr = nil
+		f.mu.Unlock()
+		return nil, err
+	}
+	f.mu.Unlock()
+
+	decoded, err := lightning.DecodePayReq(payReq)
+	if err != nil {
+		return nil, err
+	}
+	if decoded.PaymentHash == nil {
+		return nil, errors.New("invoice without payment hash")
+	}
+
+	amountSats := int64(0)
+	if decoded.MilliSat != nil {
+		amountSats = int64(*decoded.MilliSat) / 1000
+	}
+
+	f.mu.Lock()
+	f.payments = append(f.payments, &Payment{
+		PayReq:     payReq,
+		AmountSats: amountSats,
+	})
+	f.mu.Unlock()
+
+	hash := lntypes.Hash(*decoded.PaymentHash)
+	_ = f.Settle(hash)
+
+	return &lightning.Payment{
+		Hash:       hash,
+		AmountSats: amountSats,
+	}, nil
+}
+
+// FailNextPayment makes the next PayInvoice call return err.
+func (f *Fake) FailNextPayment(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.payErr = err
+}
+
+// Payments returns every payment made through PayInvoice, oldest first.
+func (f *Fake) Payments() []*Payment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payments := make([]*Payment, len(f.payments))
+	copy(payments, f.payments)
+
+	return payments
+}
+
+// SubscribeInvoices streams the settlement, expiry or failure of every invoice until ctx is done.
+func (f *Fake) SubscribeInvoices(ctx context.Context) (chan *lightning.InvoiceUpdate, chan error) {
+	sub := &subscriber{
+		ctx:     ctx,
+		updates: make(chan *lightning.InvoiceUpdate),
+	}
+	errors := make(chan error)
+
+	f.mu.Lock()
+	f.subscribers[sub] = struct{}{}
+	f.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		f.mu.Lock()
+		delete(f.subscribers, sub)
+		f.mu.Unlock()
+
+		close(errors)
+	}()
+
+	return sub.updates, errors
+}
+
+// Settle marks the invoice as paid. Every TrackInvoice call for it receives a settled update.
+func (f *Fake) Settle(hash lntypes.Hash) error {
+	return f.finish(hash, StateSettled, nil)
+}
+
+// Expire marks the invoice as expired. Every TrackInvoice call for it receives lightning.ErrInvoiceExpired.
+func (f *Fake) Expire(hash lntypes.Hash) error {
+	return f.finish(hash, StateExpired, lightning.ErrInvoiceExpired)
+}
+
+// Fail makes every TrackInvoice call for the invoice receive err, as if the backend had failed.
+func (f *Fake) Fail(hash lntypes.Hash, err error) error {
+	return f.finish(hash, StateFailed, err)
+}
+
+// FailNextAddInvoice makes the next AddInvoice call return err.
+func (f *Fake) FailNextAddInvoice(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.addInvoiceErr = err
+}
+
+// State returns the current state of the invoice.
+func (f *Fake) State(hash lntypes.Hash) (State, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	inv, ok := f.invoices[hash]
+	if !ok {
+		return 0, ErrInvoiceNotFound
+	}
+
+	return inv.state, nil
+}
+
+// Invoices returns every invoice created so far, oldest first.
+func (f *Fake) Invoices() []*lightning.Invoice {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	invoices := make([]*lightning.Invoice, 0, len(f.order))
+	for i := range f.order {
+		invoices = append(invoices, f.invoices[f.order[i]].invoice)
+	}
+
+	return invoices
+}
+
+// HashFromPayReq returns the payment hash of an invoice created by this service, e.g. one read back from an
+// `amount` tag of a feedback event.
+func (f *Fake) HashFromPayReq(payReq string) (lntypes.Hash, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for hash, inv := range f.invoices {
+		if inv.invoice.PayReq == payReq {
+			return hash, nil
+		}
+	}
+
+	return lntypes.Hash{}, ErrInvoiceNotFound
+}
+
+func (f *Fake) finish(hash lntypes.Hash, state State, err error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	inv, ok := f.invoices[hash]
+	if !ok {
+		return ErrInvoiceNotFound
+	}
+
+	if inv.state != StateOpen {
+		return ErrInvoiceNotOpen
+	}
+
+	inv.state = state
+	inv.err = err
+	close(inv.done)
+
+	update := &lightning.InvoiceUpdate{Hash: hash, Settled: state == StateSettled, Err: err}
+	for sub := range f.subscribers {
+		go func(sub *subscriber) {
+			select {
+			case sub.updates <- update:
+			case <-sub.ctx.Done():
+			}
+		}(sub)
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+
+	"github.com/sebdeveloper6952/godvm/lightning"
+)
+
+func newFake(t *testing.T, opts ...Option) *Fake {
+	t.Helper()
+
+	f, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestAddInvoice(t *testing.T) {
+	f := newFake(t)
+
+	invoice, err := f.AddInvoice(context.Background(), 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := lightning.DecodePayReq(invoice.PayReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.MilliSat == nil || *decoded.MilliSat != 21_000 {
+		t.Errorf("got amount %v msats, want 21000", decoded.MilliSat)
+	}
+	if decoded.PaymentHash == nil || lntypes.Hash(*decoded.PaymentHash) != invoice.Hash {
+		t.Errorf("bolt11 doesn't commit to the invoice hash %s", invoice.Hash)
+	}
+
+	hash, err := f.HashFromPayReq(invoice.PayReq)
+	if err != nil || hash != invoice.Hash {
+		t.Errorf("got hash %s, %v, want %s", hash, err, invoice.Hash)
+	}
+	if state, _ := f.State(invoice.Hash); state != StateOpen {
+		t.Errorf("got state %d, want open", state)
+	}
+}
+
+func TestFailNextAddInvoice(t *testing.T) {
+	f := newFake(t)
+	failure := errors.New("backend down")
+
+	f.FailNextAddInvoice(failure)
+	if _, err := f.AddInvoice(context.Background(), 1); !errors.Is(err, failure) {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+	if _, err := f.AddInvoice(context.Background(), 1); err != nil {
+		t.Fatalf("only the next call must fail, got %v", err)
+	}
+	if len(f.Invoices()) != 1 {
+		t.Errorf("got %d invoices, want 1", len(f.Invoices()))
+	}
+}
+
+func TestTrackInvoice(t *testing.T) {
+	failure := errors.New("backend down")
+
+	tests := []struct {
+		name    string
+		finish  func(f *Fake, hash lntypes.Hash) error
+		state   State
+		wantErr error
+	}{
+		{
+			name:   "settled",
+			finish: (*Fake).Settle,
+			state:  StateSettled,
+		},
+		{
+			name:    "expired",
+			finish:  (*Fake).Expire,
+			state:   StateExpired,
+			wantErr: lightning.ErrInvoiceExpired,
+		},
+		{
+			name: "failed",
+			finish: func(f *Fake, hash lntypes.Hash) error {
+				return f.Fail(hash, failure)
+			},
+			state:   StateFailed,
+			wantErr: failure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			f := newFake(t)
+			invoice, err := f.AddInvoice(ctx, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			updates, errs := f.TrackInvoice(ctx, invoice)
+			if err := tt.finish(f, invoice.Hash); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case update := <-updates:
+				if tt.wantErr != nil || !update.Settled {
+					t.Errorf("got update %+v, want error %v", update, tt.wantErr)
+				}
+			case err := <-errs:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the invoice")
+			}
+
+			if state, _ := f.State(invoice.Hash); state != tt.state {
+				t.Errorf("got state %d, want %d", state, tt.state)
+			}
+			if err := tt.finish(f, invoice.Hash); !errors.Is(err, ErrInvoiceNotOpen) {
+				t.Errorf("finishing twice got error %v, want %v", err, ErrInvoiceNotOpen)
+			}
+		})
+	}
+}
+
+func TestTrackUnknownInvoice(t *testing.T) {
+	f := newFake(t)
+
+	updates, errs := f.TrackInvoice(context.Background(), &lightning.Invoice{})
+	select {
+	case update := <-updates:
+		t.Fatalf("got update %+v for an unknown invoice", update)
+	case err := <-errs:
+		if !errors.Is(err, ErrInvoiceNotFound) {
+			t.Fatalf("got error %v, want %v", err, ErrInvoiceNotFound)
+		}
+	}
+}
+
+func TestAutoSettle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := newFake(t, WithAutoSettle(time.Millisecond))
+	invoice, err := f.AddInvoice(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates, errs := f.TrackInvoice(ctx, invoice)
+	select {
+	case update := <-updates:
+		if !update.Settled {
+			t.Fatalf("got update %+v, want a settlement", update)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("invoice not settled by itself")
+	}
+}
+
+func TestPayInvoice(t *testing.T) {
+	f := newFake(t)
+	invoice, err := f.AddInvoice(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("no route")
+	f.FailNextPayment(failure)
+	if _, err := f.PayInvoice(context.Background(), invoice.PayReq); !errors.Is(err, failure) {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+
+	payment, err := f.PayInvoice(context.Background(), invoice.PayReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Hash != invoice.Hash || payment.AmountSats != 42 {
+		t.Errorf("got payment %+v, want 42 sats to %s", payment, invoice.Hash)
+	}
+
+	payments := f.Payments()
+	if len(payments) != 1 || payments[0].PayReq != invoice.PayReq || payments[0].AmountSats != 42 {
+		t.Errorf("got payments %+v, want the one paid", payments)
+	}
+	// paying an invoice of the same service settles it.
+	if state, _ := f.State(invoice.Hash); state != StateSettled {
+		t.Errorf("got state %d, want settled", state)
+	}
+}
+
+func TestSubscribeInvoices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := newFake(t)
+	settled, err := f.AddInvoice(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := f.AddInvoice(ctx, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates, errs := f.SubscribeInvoices(ctx)
+	if err := f.Settle(settled.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Expire(expired.Hash); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[lntypes.Hash]*lightning.InvoiceUpdate)
+	for len(got) < 2 {
+		select {
+		case update := <-updates:
+			got[update.Hash] = update
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d updates", len(got))
+		}
+	}
+	if update := got[settled.Hash]; !update.Settled || update.Err != nil {
+		t.Errorf("got update %+v, want a settlement", update)
+	}
+	if update := got[expired.Hash]; update.Settled || !errors.Is(update.Err, lightning.ErrInvoiceExpired) {
+		t.Errorf("got update %+v, want an expiry", update)
+	}
+
+	// the stream ends with ctx.
+	cancel()
+	select {
+	case _, ok := <-errs:
+		if ok {
+			t.Error("got an error after ctx was done")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not ended after ctx was done")
+	}
+}
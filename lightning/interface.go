@@ -2,20 +2,37 @@ package lightning
 
 import (
 	"context"
+	"errors"
 
 	"github.com/lightningnetwork/lnd/lntypes"
 )
 
+var (
+	ErrInvoiceExpired = errors.New("invoice expired")
+)
+
 type Invoice struct {
 	Hash   lntypes.Hash
 	PayReq string
 }
 
 type InvoiceUpdate struct {
+	// Hash of the updated invoice. Only set by InvoiceSubscriber streams.
+	Hash    lntypes.Hash
 	Settled bool
+
+	// Err is set by InvoiceSubscriber streams when the invoice can no longer be paid, e.g. ErrInvoiceExpired.
+	Err error
 }
 
 type Service interface {
 	AddInvoice(ctx context.Context, amountSats int64) (*Invoice, error)
 	TrackInvoice(ctx context.Context, invoice *Invoice) (chan *InvoiceUpdate, chan error)
 }
+
+// InvoiceSubscriber is implemented by services that can stream the updates of all their invoices over a single
+// subscription, instead of one TrackInvoice call per invoice. The stream ends with an error, after which callers
+// should fall back to TrackInvoice for the invoices they are still waiting on.
+type InvoiceSubscriber interface {
+	SubscribeInvoices(ctx context.Context) (chan *InvoiceUpdate, chan error)
+}
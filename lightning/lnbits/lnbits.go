@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lightningnetwork/lnd/lntypes"
@@ -12,9 +17,56 @@ import (
 	"github.com/sebdeveloper6952/godvm/lightning"
 )
 
+const (
+	defaultPollInterval = 5 * time.Second
+	maxStreamBackoff    = time.Minute
+)
+
+// StatusError is returned when LNbits answers with a non-2xx status code.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("lnbits: unexpected status %d: %s", e.Code, e.Body)
+}
+
+type Option func(l *lnbits)
+
+// WithHTTPClient sets the client used for every request, including the payment stream. Defaults to a client
+// without timeout, since the payment stream is a long-lived request.
+func WithHTTPClient(client *http.Client) Option {
+	return func(l *lnbits) {
+		l.client = client
+	}
+}
+
+// WithPollInterval sets how often pending invoices are polled while the payment stream is down.
+func WithPollInterval(interval time.Duration) Option {
+	return func(l *lnbits) {
+		l.pollInterval = interval
+	}
+}
+
 type lnbits struct {
-	url string
-	key string
+	url          string
+	key          string
+	client       *http.Client
+	pollInterval time.Duration
+
+	mu          sync.Mutex
+	trackers    map[lntypes.Hash][]*tracker
+	stopWatcher context.CancelFunc
+
+	// pollOnly is set once the server answered that it has no payment stream, e.g. an LNbits version without it.
+	pollOnly atomic.Bool
+}
+
+// tracker is a single TrackInvoice call waiting for its invoice to be settled by the watcher.
+type tracker struct {
+	settled chan struct{}
+	err     chan error
 }
 
 type payment struct {
@@ -23,23 +75,44 @@ type payment struct {
 	Expiry int  `json:"expiry"`
 }
 
+type outgoingPayment struct {
+	Out    bool   `json:"out"`
+	Bolt11 string `json:"bolt11"`
+}
+
 type paymentResponse struct {
 	PaymentHash    string `json:"payment_hash"`
 	PaymentRequest string `json:"payment_request"`
 	Paid           bool   `json:"paid"`
 }
 
+type streamPayment struct {
+	PaymentHash string `json:"payment_hash"`
+	Pending     *bool  `json:"pending"`
+	Status      string `json:"status"`
+}
+
 func New(
 	url string,
 	key string,
+	opts ...Option,
 ) (lightning.Service, error) {
-	return &lnbits{
-		url: url,
-		key: key,
-	}, nil
+	l := &lnbits{
+		url:          url,
+		key:          key,
+		client:       &http.Client{},
+		pollInterval: defaultPollInterval,
+		trackers:     make(map[lntypes.Hash][]*tracker),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l, nil
 }
 
-func (l lnbits) AddInvoice(ctx context.Context, amountSats int64) (*lightning.Invoice, error) {
+func (l *lnbits) AddInvoice(ctx context.Context, amountSats int64) (*lightning.Invoice, error) {
 	body := &payment{
 		Out:    false,
 		Amount: int(amountSats),
@@ -51,25 +124,40 @@ func (l lnbits) AddInvoice(ctx context.Context, amountSats int64) (*lightning.In
 		return nil, err
 	}
 
-	req, err := http.NewRequest(
-		http.MethodPost,
-		l.url+"/api/v1/payments",
-		bytes.NewBuffer(bodyBytes),
-	)
+	target := &paymentResponse{}
+	if err := l.do(ctx, http.MethodPost, "/api/v1/payments", bytes.NewBuffer(bodyBytes), target); err != nil {
+		return nil, err
+	}
+
+	hash, err := lntypes.MakeHashFromStr(target.PaymentHash)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("X-Api-Key", l.key)
+	return &lightning.Invoice{
+		Hash:   hash,
+		PayReq: target.PaymentRequest,
+	}, nil
+}
 
-	res, err := http.DefaultClient.Do(req)
+// PayInvoice pays the invoice from the LNbits wallet. This requires the wallet admin key, the invoice key can only
+// create invoices.
+func (l *lnbits) PayInvoice(ctx context.Context, payReq string) (*lightning.Payment, error) {
+	decoded, err := lightning.DecodePayReq(payReq)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyBytes, err := json.Marshal(&outgoingPayment{
+		Out:    true,
+		Bolt11: payReq,
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
 	target := &paymentResponse{}
-	if err := json.NewDecoder(res.Body).Decode(target); err != nil {
+	if err := l.do(ctx, http.MethodPost, "/api/v1/payments", bytes.NewBuffer(bodyBytes), target); err != nil {
 		return nil, err
 	}
 
@@ -78,13 +166,19 @@ func (l lnbits) AddInvoice(ctx context.Context, amountSats int64) (*lightning.In
 		return nil, err
 	}
 
-	return &lightning.Invoice{
-		Hash:   hash,
-		PayReq: target.PaymentRequest,
-	}, nil
+	payment := &lightning.Payment{
+		Hash: hash,
+	}
+	if decoded.MilliSat != nil {
+		payment.AmountSats = int64(*decoded.MilliSat) / 1000
+	}
+
+	return payment, nil
 }
 
-func (l lnbits) TrackInvoice(ctx context.Context, invoice *lightning.Invoice) (chan *lightning.InvoiceUpdate, chan error) {
+// TrackInvoice waits for the invoice on the payment stream shared by all tracked invoices. The invoice is checked
+// once up front in case it was paid before tracking started.
+func (l *lnbits) TrackInvoice(ctx context.Context, invoice *lightning.Invoice) (chan *lightning.InvoiceUpdate, chan error) {
 	updates := make(chan *lightning.InvoiceUpdate)
 	errors := make(chan error)
 
@@ -92,47 +186,302 @@ func (l lnbits) TrackInvoice(ctx context.Context, invoice *lightning.Invoice) (c
 		defer close(updates)
 		defer close(errors)
 
-		req, err := http.NewRequest(
-			http.MethodGet,
-			l.url+"/api/v1/payments/"+invoice.Hash.String(),
-			http.NoBody,
-		)
-		if err != nil {
-			errors <- err
+		t := l.register(invoice.Hash)
+		defer l.unregister(invoice.Hash, t)
+
+		paid, err := l.paymentPaid(ctx, invoice.Hash)
+		if err != nil && isFatal(err) {
+			select {
+			case errors <- err:
+			case <-ctx.Done():
+			}
 			return
 		}
 
-		req.Header.Set("X-Api-Key", l.key)
-
-		for {
+		if !paid {
 			select {
-			case <-time.After(time.Second):
-				res, err := http.DefaultClient.Do(req)
-				if err != nil {
-					errors <- err
-					return
-				}
-
-				target := &paymentResponse{}
-				if err := json.NewDecoder(res.Body).Decode(target); err != nil {
-					errors <- err
-					res.Body.Close()
-					return
-				}
-
-				if target.Paid {
-					updates <- &lightning.InvoiceUpdate{
-						Settled: true,
-					}
-					res.Body.Close()
-					return
+			case <-t.settled:
+			case err := <-t.err:
+				select {
+				case errors <- err:
+				case <-ctx.Done():
 				}
+				return
 			case <-ctx.Done():
 				return
 			}
 		}
 
+		select {
+		case updates <- &lightning.InvoiceUpdate{Settled: true}:
+		case <-ctx.Done():
+		}
 	}()
 
 	return updates, errors
 }
+
+func (l *lnbits) register(hash lntypes.Hash) *tracker {
+	t := &tracker{
+		settled: make(chan struct{}),
+		err:     make(chan error, 1),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.trackers[hash] = append(l.trackers[hash], t)
+	if l.stopWatcher == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		l.stopWatcher = cancel
+		go l.watch(ctx)
+	}
+
+	return t
+}
+
+func (l *lnbits) unregister(hash lntypes.Hash, t *tracker) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	trackers := l.trackers[hash]
+	for i := range trackers {
+		if trackers[i] == t {
+			trackers = append(trackers[:i], trackers[i+1:]...)
+			break
+		}
+	}
+
+	if len(trackers) == 0 {
+		delete(l.trackers, hash)
+	} else {
+		l.trackers[hash] = trackers
+	}
+
+	if len(l.trackers) == 0 && l.stopWatcher != nil {
+		l.stopWatcher()
+		l.stopWatcher = nil
+	}
+}
+
+func (l *lnbits) settle(hash lntypes.Hash) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, t := range l.trackers[hash] {
+		close(t.settled)
+	}
+	delete(l.trackers, hash)
+}
+
+func (l *lnbits) fail(hash lntypes.Hash, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, t := range l.trackers[hash] {
+		t.err <- err
+	}
+	delete(l.trackers, hash)
+}
+
+func (l *lnbits) pending() []lntypes.Hash {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hashes := make([]lntypes.Hash, 0, len(l.trackers))
+	for hash := range l.trackers {
+		hashes = append(hashes, hash)
+	}
+
+	return hashes
+}
+
+// watch keeps the payment stream open while there are tracked invoices. Whenever the stream is down, pending
+// invoices are polled until the stream can be reconnected, with exponential backoff between attempts. Servers
+// without a payment stream are only polled. Errors of the stream never fail the invoices, only those of polling them.
+func (l *lnbits) watch(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		if l.pollOnly.Load() {
+			l.poll(ctx)
+
+			select {
+			case <-time.After(l.pollInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		connected, err := l.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		if streamUnsupported(err) {
+			l.pollOnly.Store(true)
+			continue
+		}
+
+		retryAt := time.Now().Add(backoff)
+		for time.Now().Before(retryAt) {
+			l.poll(ctx)
+
+			select {
+			case <-time.After(l.pollInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		backoff *= 2
+		if backoff > maxStreamBackoff {
+			backoff = maxStreamBackoff
+		}
+	}
+}
+
+// stream reads the LNbits server-sent events payment stream until it fails. It reports whether the stream was
+// established at all, so the caller can reset its backoff.
+func (l *lnbits) stream(ctx context.Context) (bool, error) {
+	req, err := l.newRequest(ctx, http.MethodGet, "/api/v1/payments/sse", http.NoBody)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res); err != nil {
+		return false, err
+	}
+
+	// catch up on payments that happened while the stream was down
+	l.poll(ctx)
+
+	events := newEventReader(res.Body)
+	for {
+		event, data, err := events.next()
+		if err != nil {
+			return true, err
+		}
+
+		if event != "payment-received" {
+			continue
+		}
+
+		p := &streamPayment{}
+		if err := json.Unmarshal(data, p); err != nil {
+			continue
+		}
+
+		if (p.Pending != nil && *p.Pending) || (p.Status != "" && p.Status != "success") {
+			continue
+		}
+
+		hash, err := lntypes.MakeHashFromStr(p.PaymentHash)
+		if err != nil {
+			continue
+		}
+
+		l.settle(hash)
+	}
+}
+
+func (l *lnbits) poll(ctx context.Context) {
+	for _, hash := range l.pending() {
+		paid, err := l.paymentPaid(ctx, hash)
+		if err != nil {
+			if isFatal(err) {
+				l.fail(hash, err)
+			}
+			continue
+		}
+
+		if paid {
+			l.settle(hash)
+		}
+	}
+}
+
+func (l *lnbits) paymentPaid(ctx context.Context, hash lntypes.Hash) (bool, error) {
+	target := &paymentResponse{}
+	if err := l.do(ctx, http.MethodGet, "/api/v1/payments/"+hash.String(), http.NoBody, target); err != nil {
+		return false, err
+	}
+
+	return target.Paid, nil
+}
+
+func (l *lnbits) do(ctx context.Context, method string, path string, body io.Reader, target interface{}) error {
+	req, err := l.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
+
+func (l *lnbits) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, l.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Api-Key", l.key)
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	return &StatusError{
+		Code: res.StatusCode,
+		Body: string(body),
+	}
+}
+
+// streamUnsupported reports whether the server has no payment stream endpoint.
+func streamUnsupported(err error) bool {
+	statusErr := &StatusError{}
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	return statusErr.Code == http.StatusNotFound || statusErr.Code == http.StatusMethodNotAllowed
+}
+
+// isFatal reports whether retrying the request can't possibly succeed, e.g. a wrong key or an unknown payment.
+func isFatal(err error) bool {
+	statusErr := &StatusError{}
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	return statusErr.Code >= 400 && statusErr.Code < 500 && statusErr.Code != http.StatusTooManyRequests
+}
@@ -0,0 +1,303 @@
+package lnbits
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+
+	"github.com/sebdeveloper6952/godvm/lightning"
+	"github.com/sebdeveloper6952/godvm/lightning/fake"
+)
+
+const testKey = "invoice-key"
+
+// server is a minimal LNbits wallet API, issuing its invoices with a fake backend.
+type server struct {
+	*httptest.Server
+
+	ln  *fake.Fake
+	sse bool
+
+	// stream receives the events written to the connected payment streams.
+	stream chan string
+
+	mu       sync.Mutex
+	paid     map[string]bool
+	payments []string
+}
+
+func newServer(t *testing.T, sse bool) *server {
+	t.Helper()
+
+	ln, err := fake.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{
+		ln:     ln,
+		sse:    sse,
+		stream: make(chan string),
+		paid:   make(map[string]bool),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Api-Key") != testKey {
+		http.Error(w, "invalid key", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/payments":
+		body := struct {
+			Out    bool   `json:"out"`
+			Amount int64  `json:"amount"`
+			Bolt11 string `json:"bolt11"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if body.Out {
+			payment, err := s.ln.PayInvoice(r.Context(), body.Bolt11)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.mu.Lock()
+			s.payments = append(s.payments, body.Bolt11)
+			s.mu.Unlock()
+			writeJSON(w, &paymentResponse{PaymentHash: payment.Hash.String()})
+			return
+		}
+
+		invoice, err := s.ln.AddInvoice(r.Context(), body.Amount)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, &paymentResponse{PaymentHash: invoice.Hash.String(), PaymentRequest: invoice.PayReq})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/payments/sse":
+		if !s.sse {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-s.stream:
+				fmt.Fprint(w, event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/payments/"):
+		hash := strings.TrimPrefix(r.URL.Path, "/api/v1/payments/")
+		s.mu.Lock()
+		paid := s.paid[hash]
+		s.mu.Unlock()
+		writeJSON(w, &paymentResponse{PaymentHash: hash, Paid: paid})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *server) pay(hash lntypes.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paid[hash.String()] = true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func waitSettled(t *testing.T, updates chan *lightning.InvoiceUpdate, errs chan error) {
+	t.Helper()
+
+	select {
+	case update := <-updates:
+		if !update.Settled {
+			t.Fatalf("got update %+v, want a settlement", update)
+		}
+	case err := <-errs:
+		t.Fatalf("got error %v, want a settlement", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a settlement")
+	}
+}
+
+func TestAddInvoice(t *testing.T) {
+	s := newServer(t, true)
+	svc, _ := New(s.URL, testKey)
+
+	invoice, err := svc.AddInvoice(context.Background(), 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued := s.ln.Invoices()
+	if len(issued) != 1 || issued[0].Hash != invoice.Hash || issued[0].PayReq != invoice.PayReq {
+		t.Errorf("got invoice %+v, want %+v", invoice, issued)
+	}
+}
+
+func TestAddInvoiceWithWrongKey(t *testing.T) {
+	s := newServer(t, true)
+	svc, _ := New(s.URL, "wrong")
+
+	_, err := svc.AddInvoice(context.Background(), 21)
+	statusErr := &StatusError{}
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusUnauthorized {
+		t.Fatalf("got error %v, want status %d", err, http.StatusUnauthorized)
+	}
+}
+
+func TestPayInvoice(t *testing.T) {
+	s := newServer(t, true)
+	svc, _ := New(s.URL, testKey)
+
+	invoice, err := s.ln.AddInvoice(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := svc.(lightning.Payer).PayInvoice(context.Background(), invoice.PayReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Hash != invoice.Hash || payment.AmountSats != 42 {
+		t.Errorf("got payment %+v, want 42 sats to %s", payment, invoice.Hash)
+	}
+	if len(s.payments) != 1 || s.payments[0] != invoice.PayReq {
+		t.Errorf("got payments %v, want the invoice", s.payments)
+	}
+}
+
+func TestTrackInvoiceOnTheStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newServer(t, true)
+	svc, _ := New(s.URL, testKey, WithPollInterval(time.Hour))
+
+	invoice, err := svc.AddInvoice(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates, errs := svc.TrackInvoice(ctx, invoice)
+
+	received := func(fields string) string {
+		return fmt.Sprintf("event: payment-received\ndata: {\"payment_hash\":%q,%s}\n\n", invoice.Hash, fields)
+	}
+
+	// pending payments and other events are skipped.
+	s.stream <- ": keep-alive\n\n"
+	s.stream <- received(`"pending":true`)
+	s.stream <- "event: other\ndata: {}\n\n"
+	s.stream <- received(`"status":"success"`)
+
+	waitSettled(t, updates, errs)
+}
+
+func TestTrackInvoiceByPolling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the server has no payment stream, so the invoice is polled.
+	s := newServer(t, false)
+	svc, _ := New(s.URL, testKey, WithPollInterval(10*time.Millisecond))
+
+	invoice, err := svc.AddInvoice(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates, errs := svc.TrackInvoice(ctx, invoice)
+	s.pay(invoice.Hash)
+
+	waitSettled(t, updates, errs)
+}
+
+func TestTrackInvoicePaidBeforehand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newServer(t, true)
+	svc, _ := New(s.URL, testKey, WithPollInterval(time.Hour))
+
+	invoice, err := svc.AddInvoice(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.pay(invoice.Hash)
+
+	updates, errs := svc.TrackInvoice(ctx, invoice)
+	waitSettled(t, updates, errs)
+}
+
+func TestTrackInvoiceWithWrongKey(t *testing.T) {
+	s := newServer(t, true)
+	svc, _ := New(s.URL, "wrong")
+
+	updates, errs := svc.TrackInvoice(context.Background(), &lightning.Invoice{})
+	select {
+	case update := <-updates:
+		t.Fatalf("got update %+v, want an error", update)
+	case err := <-errs:
+		if !isFatal(err) {
+			t.Fatalf("got error %v, want a fatal one", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an error")
+	}
+}
+
+func TestEventReader(t *testing.T) {
+	stream := ": comment\n\nevent: payment-received\ndata: {\"a\":\ndata: 1}\n\ndata: unnamed\n\n"
+	events := newEventReader(strings.NewReader(stream))
+
+	want := []struct {
+		event string
+		data  string
+	}{
+		{"payment-received", "{\"a\":\n1}"},
+		{"message", "unnamed"},
+	}
+	for _, w := range want {
+		event, data, err := events.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event != w.event || string(data) != w.data {
+			t.Errorf("got event %q with data %q, want %q with %q", event, data, w.event, w.data)
+		}
+	}
+
+	if _, _, err := events.next(); err == nil {
+		t.Error("got an event past the end of the stream")
+	}
+}
@@ -0,0 +1,66 @@
+package lnbits
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// eventReader reads a server-sent events stream one event at a time.
+type eventReader struct {
+	scanner *bufio.Scanner
+}
+
+func newEventReader(r io.Reader) *eventReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	return &eventReader{
+		scanner: scanner,
+	}
+}
+
+// next blocks until a complete event has been read and returns its name and data. Events without a name are
+// reported as "message", as the spec says.
+func (r *eventReader) next() (string, []byte, error) {
+	var (
+		event string
+		data  bytes.Buffer
+	)
+
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+
+		if line == "" {
+			if data.Len() == 0 && event == "" {
+				continue
+			}
+			if event == "" {
+				event = "message"
+			}
+			return event, bytes.TrimSuffix(data.Bytes(), []byte("\n")), nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return "", nil, err
+	}
+
+	return "", nil, io.EOF
+}
@@ -3,14 +3,10 @@ package lnd
 import (
 	"context"
 	"crypto/rand"
-	"crypto/tls"
-	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"time"
+	"sync/atomic"
 
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/lightninglabs/lndclient"
 	"github.com/lightningnetwork/lnd/invoices"
 	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
@@ -26,10 +22,10 @@ type lnd struct {
 	httpPort    string
 	macaroonHex string
 	svc         *lndclient.GrpcLndServices
-}
 
-type lndInvoice struct {
-	Settled bool `json:"settled"`
+	// settleIndex is the last settle index seen by SubscribeInvoices, used to replay the settlements missed
+	// between subscriptions.
+	settleIndex atomic.Uint64
 }
 
 func New(
@@ -51,11 +47,11 @@ func New(
 	}
 
 	return &lnd{
-		address,
-		grpcPort,
-		httpPort,
-		macaroonHex,
-		svc,
+		address:     address,
+		grpcPort:    grpcPort,
+		httpPort:    httpPort,
+		macaroonHex: macaroonHex,
+		svc:         svc,
 	}, nil
 }
 
@@ -86,6 +82,43 @@ func (l *lnd) AddInvoice(
 
 }
 
+// PayInvoice pays the invoice. The macaroon must allow sending payments, e.g. admin.macaroon.
+func (l *lnd) PayInvoice(
+	ctx context.Context,
+	payReq string,
+) (*lightning.Payment, error) {
+	decoded, err := lightning.DecodePayReq(payReq)
+	if err != nil {
+		return nil, err
+	}
+
+	amountSats := int64(0)
+	if decoded.MilliSat != nil {
+		amountSats = int64(*decoded.MilliSat) / 1000
+	}
+
+	select {
+	case result := <-l.svc.Client.PayInvoice(
+		ctx,
+		payReq,
+		btcutil.Amount(lightning.MaxFeeSats(amountSats)),
+		nil,
+	):
+		if result.Err != nil {
+			return nil, result.Err
+		}
+
+		return &lightning.Payment{
+			Hash:       result.Preimage.Hash(),
+			Preimage:   result.Preimage,
+			AmountSats: int64(result.PaidAmt),
+			FeeSats:    int64(result.PaidFee),
+		}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (l *lnd) TrackInvoice(
 	ctx context.Context,
 	invoice *lightning.Invoice,
@@ -134,10 +167,9 @@ func (l *lnd) trackInvoiceGrpc(
 	return updates, errors
 }
 
-func (l *lnd) trackInvoiceHttp(
-	ctx context.Context,
-	invoice *lightning.Invoice,
-) (chan *lightning.InvoiceUpdate, chan error) {
+// SubscribeInvoices streams the settlements and cancellations of every invoice of the node. Settlements that
+// happened since the previous subscription ended are replayed first.
+func (l *lnd) SubscribeInvoices(ctx context.Context) (chan *lightning.InvoiceUpdate, chan error) {
 	updates := make(chan *lightning.InvoiceUpdate)
 	errors := make(chan error)
 
@@ -145,57 +177,53 @@ func (l *lnd) trackInvoiceHttp(
 		defer close(updates)
 		defer close(errors)
 
-		req, err := http.NewRequest(
-			http.MethodGet,
-			fmt.Sprintf("https://%s:%s/v2/invoices/subscribe/%s", l.address, l.httpPort, invoice.Hash.String()),
-			http.NoBody,
-		)
+		u, errs, err := l.svc.Client.SubscribeInvoices(ctx, lndclient.InvoiceSubscriptionRequest{
+			SettleIndex: l.settleIndex.Load(),
+		})
 		if err != nil {
-			errors <- err
+			select {
+			case errors <- err:
+			case <-ctx.Done():
+			}
 			return
 		}
 
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Grpc-Metadata-macaroon", l.macaroonHex)
-
 		for {
 			select {
-			case <-time.After(time.Second):
-				http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-				res, err := http.DefaultClient.Do(req)
-				if err != nil {
-					errors <- err
+			case invoice, ok := <-u:
+				if !ok {
 					return
 				}
-
-				target := &lndInvoice{}
-				b, err := io.ReadAll(res.Body)
-				log.Printf("%s\n", b)
-				if err != nil {
-					errors <- err
-					res.Body.Close()
-					return
+				update := &lightning.InvoiceUpdate{Hash: invoice.Hash}
+				switch invoice.State {
+				case invoices.ContractSettled:
+					update.Settled = true
+					if invoice.SettleIndex > l.settleIndex.Load() {
+						l.settleIndex.Store(invoice.SettleIndex)
+					}
+				case invoices.ContractCanceled:
+					update.Err = lightning.ErrInvoiceExpired
+				default:
+					continue
 				}
-				if err := json.NewDecoder(res.Body).Decode(target); err != nil {
-					errors <- err
-					res.Body.Close()
+				select {
+				case updates <- update:
+				case <-ctx.Done():
 					return
 				}
-
-				log.Println(target)
-
-				if target.Settled {
-					updates <- &lightning.InvoiceUpdate{
-						Settled: true,
-					}
-					res.Body.Close()
+			case err, ok := <-errs:
+				if !ok {
 					return
 				}
+				select {
+				case errors <- err:
+				case <-ctx.Done():
+				}
+				return
 			case <-ctx.Done():
 				return
 			}
 		}
-
 	}()
 
 	return updates, errors
@@ -0,0 +1,274 @@
+package lnd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+
+	"github.com/sebdeveloper6952/godvm/lightning"
+	"github.com/sebdeveloper6952/godvm/lightning/fake"
+)
+
+var testMacaroon = []byte("macaroon")
+
+// restServer is a minimal lnd REST API, issuing its invoices with a fake backend. States are streamed to the
+// invoice subscriptions in order, one JSON message each.
+type restServer struct {
+	*httptest.Server
+
+	ln     *fake.Fake
+	states []string
+}
+
+func newRESTServer(t *testing.T, states ...string) *restServer {
+	t.Helper()
+
+	ln, err := fake.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &restServer{ln: ln, states: states}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *restServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Grpc-Metadata-macaroon") != hex.EncodeToString(testMacaroon) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(&restError{Code: 2, Message: "verification failed"})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/invoices":
+		req := &restAddInvoiceRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		amountSats := int64(0)
+		fmt.Sscan(req.Value, &amountSats)
+
+		invoice, err := s.ln.AddInvoice(r.Context(), amountSats)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&restAddInvoiceResponse{
+			RHash:          base64.StdEncoding.EncodeToString(invoice.Hash[:]),
+			PaymentRequest: invoice.PayReq,
+		})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/channels/transactions":
+		req := &restSendRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.FeeLimit.Fixed == "" {
+			_ = json.NewEncoder(w).Encode(&restSendResponse{PaymentError: "no fee limit"})
+			return
+		}
+
+		preimage := lntypes.Preimage{1}
+		_ = json.NewEncoder(w).Encode(&restSendResponse{
+			PaymentPreimage: base64.StdEncoding.EncodeToString(preimage[:]),
+			PaymentRoute:    &restRoute{TotalFees: "2"},
+		})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/invoices/subscribe/"):
+		for _, state := range s.states {
+			_ = json.NewEncoder(w).Encode(&restStreamMessage{Result: &restInvoice{State: state}})
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&restError{Code: 5, Message: "not found"})
+	}
+}
+
+// newRESTClient writes the certificate and the macaroon to files, and connects to s with them.
+func newRESTClient(t *testing.T, s *httptest.Server, cert []byte) lightning.Service {
+	t.Helper()
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "tls.cert")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	if err := os.WriteFile(certPath, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	macaroonPath := filepath.Join(dir, "invoice.macaroon")
+	if err := os.WriteFile(macaroonPath, testMacaroon, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	svc, err := NewREST(RESTConfig{
+		Host:         strings.TrimPrefix(s.URL, "https://"),
+		TLSCertPath:  certPath,
+		MacaroonPath: macaroonPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return svc
+}
+
+func TestNewRESTConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  RESTConfig
+	}{
+		{"no host", RESTConfig{DataDir: "/lnd"}},
+		{"no cert", RESTConfig{Host: "localhost:8080", MacaroonPath: "/invoice.macaroon"}},
+		{"no macaroon", RESTConfig{Host: "localhost:8080", TLSCertPath: "/tls.cert"}},
+		{"missing files", RESTConfig{Host: "localhost:8080", DataDir: t.TempDir()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewREST(tt.cfg); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestRESTAddInvoice(t *testing.T) {
+	s := newRESTServer(t)
+	svc := newRESTClient(t, s.Server, s.Certificate().Raw)
+
+	invoice, err := svc.AddInvoice(context.Background(), 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued := s.ln.Invoices()
+	if len(issued) != 1 || issued[0].Hash != invoice.Hash || issued[0].PayReq != invoice.PayReq {
+		t.Errorf("got invoice %+v, want %+v", invoice, issued)
+	}
+	decoded, err := lightning.DecodePayReq(invoice.PayReq)
+	if err != nil || decoded.MilliSat == nil || *decoded.MilliSat != 21_000 {
+		t.Errorf("got bolt11 %+v, %v, want 21 sats", decoded, err)
+	}
+}
+
+func TestRESTPinsTheCertificate(t *testing.T) {
+	s := newRESTServer(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	other, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client trusts another self-signed certificate than the one of s.
+	svc := newRESTClient(t, s.Server, other)
+
+	if _, err := svc.AddInvoice(context.Background(), 21); !errors.Is(err, ErrCertificateMismatch) {
+		t.Fatalf("got error %v, want %v", err, ErrCertificateMismatch)
+	}
+}
+
+func TestRESTError(t *testing.T) {
+	s := newRESTServer(t)
+	svc := newRESTClient(t, s.Server, s.Certificate().Raw)
+	svc.(*lndREST).macaroonHex = "00"
+
+	_, err := svc.AddInvoice(context.Background(), 21)
+	restErr := &restError{}
+	if !errors.As(err, &restErr) || restErr.Message != "verification failed" {
+		t.Fatalf("got error %v, want the lnd error", err)
+	}
+}
+
+func TestRESTPayInvoice(t *testing.T) {
+	s := newRESTServer(t)
+	svc := newRESTClient(t, s.Server, s.Certificate().Raw)
+
+	invoice, err := s.ln.AddInvoice(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := svc.(lightning.Payer).PayInvoice(context.Background(), invoice.PayReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preimage := lntypes.Preimage{1}
+	if payment.Preimage != preimage || payment.Hash != preimage.Hash() {
+		t.Errorf("got preimage %s and hash %s, want %s", payment.Preimage, payment.Hash, preimage)
+	}
+	if payment.AmountSats != 42 || payment.FeeSats != 2 {
+		t.Errorf("got %d sats and %d fee sats, want 42 and 2", payment.AmountSats, payment.FeeSats)
+	}
+}
+
+func TestRESTTrackInvoice(t *testing.T) {
+	tests := []struct {
+		name    string
+		states  []string
+		wantErr error
+	}{
+		{"settled", []string{"OPEN", "SETTLED"}, nil},
+		{"canceled", []string{"OPEN", "CANCELED"}, lightning.ErrInvoiceExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			s := newRESTServer(t, tt.states...)
+			svc := newRESTClient(t, s.Server, s.Certificate().Raw)
+
+			updates, errs := svc.TrackInvoice(ctx, &lightning.Invoice{Hash: lntypes.Hash{1}})
+			select {
+			case update := <-updates:
+				if tt.wantErr != nil || !update.Settled {
+					t.Errorf("got update %+v, want error %v", update, tt.wantErr)
+				}
+			case err := <-errs:
+				if tt.wantErr == nil || !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the invoice")
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package lightning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidLightningAddress = errors.New("invalid lightning address")
+)
+
+type lnurlPayParams struct {
+	Status         string `json:"status"`
+	Reason         string `json:"reason"`
+	Tag            string `json:"tag"`
+	Callback       string `json:"callback"`
+	MinSendable    int64  `json:"minSendable"`
+	MaxSendable    int64  `json:"maxSendable"`
+	CommentAllowed int    `json:"commentAllowed"`
+}
+
+type lnurlPayValues struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	PR     string `json:"pr"`
+}
+
+// InvoiceFromLightningAddress asks the LNURL-pay server behind a lightning address (LUD-16) for an invoice of
+// amountSats. The invoice is checked to be for exactly that amount before it's returned.
+func InvoiceFromLightningAddress(
+	ctx context.Context,
+	client *http.Client,
+	address string,
+	amountSats int64,
+	comment string,
+) (string, error) {
+	name, domain, ok := strings.Cut(address, "@")
+	if !ok || name == "" || domain == "" {
+		return "", ErrInvalidLightningAddress
+	}
+
+	params := &lnurlPayParams{}
+	if err := getJSON(ctx, client, "https://"+domain+"/.well-known/lnurlp/"+url.PathEscape(name), params); err != nil {
+		return "", err
+	}
+	if params.Status == "ERROR" {
+		return "", fmt.Errorf("lnurl: %s", params.Reason)
+	}
+	if params.Tag != "payRequest" || params.Callback == "" {
+		return "", errors.New("lnurl: not a pay request")
+	}
+
+	amountMsats := amountSats * 1000
+	if amountMsats < params.MinSendable || amountMsats > params.MaxSendable {
+		return "", fmt.Errorf(
+			"lnurl: amount %d msats outside of [%d, %d]",
+			amountMsats,
+			params.MinSendable,
+			params.MaxSendable,
+		)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return "", err
+	}
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(amountMsats, 10))
+	if comment != "" && params.CommentAllowed > 0 {
+		if len(comment) > params.CommentAllowed {
+			comment = comment[:params.CommentAllowed]
+		}
+		query.Set("comment", comment)
+	}
+	callback.RawQuery = query.Encode()
+
+	values := &lnurlPayValues{}
+	if err := getJSON(ctx, client, callback.String(), values); err != nil {
+		return "", err
+	}
+	if values.Status == "ERROR" {
+		return "", fmt.Errorf("lnurl: %s", values.Reason)
+	}
+
+	invoice, err := DecodePayReq(values.PR)
+	if err != nil {
+		return "", err
+	}
+	if invoice.MilliSat == nil || int64(*invoice.MilliSat) != amountMsats {
+		return "", errors.New("lnurl: invoice amount does not match the requested amount")
+	}
+
+	return values.PR, nil
+}
+
+// PayLightningAddress pays amountSats to a lightning address using svc, which must be a Payer.
+func PayLightningAddress(
+	ctx context.Context,
+	svc Service,
+	client *http.Client,
+	address string,
+	amountSats int64,
+	comment string,
+) (*Payment, error) {
+	if _, ok := svc.(Payer); !ok {
+		return nil, ErrPaymentsNotSupported
+	}
+
+	payReq, err := InvoiceFromLightningAddress(ctx, client, address, amountSats, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return Pay(ctx, svc, payReq)
+}
+
+func getJSON(ctx context.Context, client *http.Client, u string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("lnurl: unexpected status %d from %s", res.StatusCode, req.URL.Host)
+	}
+
+	return json.NewDecoder(res.Body).Decode(target)
+}
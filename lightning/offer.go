@@ -0,0 +1,38 @@
+package lightning
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+var (
+	ErrOffersNotSupported = errors.New("lightning service does not support offers")
+)
+
+// Offer is a reusable BOLT12 offer. Any amount can be paid to it.
+type Offer struct {
+	ID     string
+	Bolt12 string
+}
+
+// OfferPayment is a payment received through an offer.
+type OfferPayment struct {
+	OfferID    string
+	Hash       lntypes.Hash
+	AmountSats int64
+
+	// PayerNote is the free text the payer attached to the invoice request.
+	PayerNote string
+}
+
+// Offerer is implemented by services that can receive BOLT12 payments. Services that implement it but run on a
+// node without offers enabled return ErrOffersNotSupported from CreateOffer.
+type Offerer interface {
+	CreateOffer(ctx context.Context, description string) (*Offer, error)
+
+	// SubscribeOfferPayments streams the payments received through any offer of the node, until ctx is done or the
+	// stream fails.
+	SubscribeOfferPayments(ctx context.Context) (chan *OfferPayment, chan error)
+}
@@ -0,0 +1,74 @@
+package lightning
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+var (
+	ErrPaymentsNotSupported = errors.New("lightning backend can't pay invoices")
+	ErrUnknownNetwork       = errors.New("unknown invoice network")
+)
+
+type Payment struct {
+	Hash       lntypes.Hash
+	Preimage   lntypes.Preimage
+	AmountSats int64
+	FeeSats    int64
+}
+
+// Payer is implemented by backends that can pay invoices, which is needed for refunds. Not every Service is a
+// Payer: check with a type assertion, or use Pay.
+type Payer interface {
+	PayInvoice(ctx context.Context, payReq string) (*Payment, error)
+}
+
+// Pay pays the invoice with svc, or returns ErrPaymentsNotSupported if the backend can't pay invoices.
+func Pay(ctx context.Context, svc Service, payReq string) (*Payment, error) {
+	payer, ok := svc.(Payer)
+	if !ok {
+		return nil, ErrPaymentsNotSupported
+	}
+
+	return payer.PayInvoice(ctx, payReq)
+}
+
+// DecodePayReq decodes a bolt11 invoice for whichever network its prefix names.
+func DecodePayReq(payReq string) (*zpay32.Invoice, error) {
+	net, err := networkFromPayReq(payReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return zpay32.Decode(payReq, net)
+}
+
+// MaxFeeSats is the routing fee limit applied by the backends when paying an invoice of the given amount: 1% plus
+// 10 sats to make small payments routable.
+func MaxFeeSats(amountSats int64) int64 {
+	return amountSats/100 + 10
+}
+
+func networkFromPayReq(payReq string) (*chaincfg.Params, error) {
+	payReq = strings.ToLower(payReq)
+
+	switch {
+	case strings.HasPrefix(payReq, "lnbcrt"):
+		return &chaincfg.RegressionNetParams, nil
+	case strings.HasPrefix(payReq, "lnbc"):
+		return &chaincfg.MainNetParams, nil
+	case strings.HasPrefix(payReq, "lntbs"):
+		return &chaincfg.SigNetParams, nil
+	case strings.HasPrefix(payReq, "lntb"):
+		return &chaincfg.TestNet3Params, nil
+	case strings.HasPrefix(payReq, "lnsb"):
+		return &chaincfg.SimNetParams, nil
+	}
+
+	return nil, ErrUnknownNetwork
+}
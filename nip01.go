@@ -14,6 +14,7 @@ type ProfileMetadata struct {
 	Name    string `json:"name"`
 	About   string `json:"about"`
 	Picture string `json:"picture"`
+	Lud16   string `json:"lud16,omitempty"`
 }
 
 func NewProfileMetadataEvent(
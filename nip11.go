@@ -0,0 +1,252 @@
+package godvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+const (
+	// signatureLength is how much longer the message of an event gets once its hex ID and signature are set.
+	signatureLength = 64 + 128
+
+	relayInfoTTL        = time.Hour
+	relayInfoFailureTTL = 5 * time.Minute
+	relayInfoTimeout    = 5 * time.Second
+)
+
+var (
+	ErrRelaySkipped  = errors.New("relay skipped")
+	ErrEventTooLarge = errors.New("event too large for relay")
+)
+
+// RelayPolicy tells which relays outside the relay set, e.g. the relays of a job request, are used. Relays in the
+// relay set are always used.
+type RelayPolicy struct {
+	// AllowAuthRequired allows relays whose NIP-11 document says they require NIP-42 authentication. Relays that
+	// require it without listing NIP-42 as supported are never used.
+	AllowAuthRequired bool
+
+	// AllowPaymentRequired allows relays whose NIP-11 document says they require payment.
+	AllowPaymentRequired bool
+}
+
+// ContentOffloader stores the content of a job result too large for the relays it's published to, e.g. on a file
+// host, and returns the URL it can be downloaded from.
+type ContentOffloader interface {
+	Offload(ctx context.Context, content string) (string, error)
+}
+
+// SetRelayPolicy sets which relays outside the relay set are used. By default, relays that require authentication
+// or payment are skipped.
+func (e *Engine) SetRelayPolicy(p RelayPolicy) {
+	e.nostrSvc.SetRelayPolicy(p)
+}
+
+// SetContentOffloader makes the engine offload the content of job results larger than the NIP-11 limits of the
+// relays they are published to. The content of the result is replaced with the URL returned by the offloader, and
+// the result is tagged ["offloaded", "<original length>"]. Without an offloader, large results are only published
+// to the relays that accept them.
+func (e *Engine) SetContentOffloader(o ContentOffloader) {
+	e.offloader = o
+}
+
+// offloadJobResult offloads the result content of the event, before it's signed, when it's too large for any of
+// the relays. It reports whether it did.
+func (e *Engine) offloadJobResult(ctx context.Context, ev *goNostr.Event, relays []string) bool {
+	if e.offloader == nil {
+		return false
+	}
+
+	maxMessage, maxContent := e.nostrSvc.RelayLimits(ctx, relays...)
+	length := eventMessageLength(ev) + signatureLength
+	if (maxContent == 0 || len(ev.Content) <= maxContent) && (maxMessage == 0 || length <= maxMessage) {
+		return false
+	}
+
+	url, err := e.offloader.Offload(ctx, ev.Content)
+	if err != nil {
+		e.log.Printf("offload content of job result %+v", err)
+		return false
+	}
+
+	ev.Tags = append(ev.Tags, goNostr.Tag{"offloaded", fmt.Sprintf("%d", len(ev.Content))})
+	ev.Content = url
+
+	return true
+}
+
+// relayInfos keeps the NIP-11 documents of the relays. Relays whose document can't be fetched are cached without
+// one for a shorter time, and treated as having no limits.
+type relayInfos struct {
+	mu      sync.Mutex
+	entries map[string]*relayInfoEntry
+	policy  RelayPolicy
+}
+
+type relayInfoEntry struct {
+	// ready is closed once the document was fetched, or failed to.
+	ready     chan struct{}
+	info      *nip11.RelayInformationDocument
+	expiresAt time.Time
+}
+
+func newRelayInfos() *relayInfos {
+	return &relayInfos{
+		entries: make(map[string]*relayInfoEntry),
+	}
+}
+
+func (r *relayInfos) setPolicy(p RelayPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.policy = p
+}
+
+// get returns the NIP-11 document of the relay, fetching it if it's not cached, or nil if the relay has none.
+func (r *relayInfos) get(ctx context.Context, url string) *nip11.RelayInformationDocument {
+	url = goNostr.NormalizeURL(url)
+
+	r.mu.Lock()
+	entry, ok := r.entries[url]
+	if !ok || (isClosed(entry.ready) && time.Now().After(entry.expiresAt)) {
+		entry = &relayInfoEntry{ready: make(chan struct{})}
+		r.entries[url] = entry
+		go r.fetch(url, entry)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.info
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func (r *relayInfos) fetch(url string, entry *relayInfoEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), relayInfoTimeout)
+	defer cancel()
+
+	info, err := nip11.Fetch(ctx, url)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		entry.expiresAt = time.Now().Add(relayInfoFailureTTL)
+	} else {
+		entry.info = info
+		entry.expiresAt = time.Now().Add(relayInfoTTL)
+	}
+	close(entry.ready)
+}
+
+// allowed returns an error wrapping ErrRelaySkipped when the policy doesn't allow the relay.
+func (r *relayInfos) allowed(info *nip11.RelayInformationDocument) error {
+	if info == nil || info.Limitation == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	policy := r.policy
+	r.mu.Unlock()
+
+	limitation := info.Limitation
+	if limitation.AuthRequired && (!policy.AllowAuthRequired || !slices.Contains(info.SupportedNIPs, 42)) {
+		return fmt.Errorf("%w: authentication required", ErrRelaySkipped)
+	}
+	if limitation.PaymentRequired && !policy.AllowPaymentRequired {
+		return fmt.Errorf("%w: payment required", ErrRelaySkipped)
+	}
+
+	return nil
+}
+
+// accepts returns an error wrapping ErrEventTooLarge when the event exceeds the limits of the relay.
+func accepts(info *nip11.RelayInformationDocument, ev *goNostr.Event) error {
+	if info == nil || info.Limitation == nil {
+		return nil
+	}
+
+	limitation := info.Limitation
+	if limitation.MaxContentLength > 0 && len(ev.Content) > limitation.MaxContentLength {
+		return fmt.Errorf("%w: content length %d over %d", ErrEventTooLarge, len(ev.Content), limitation.MaxContentLength)
+	}
+	if limitation.MaxEventTags > 0 && len(ev.Tags) > limitation.MaxEventTags {
+		return fmt.Errorf("%w: %d tags over %d", ErrEventTooLarge, len(ev.Tags), limitation.MaxEventTags)
+	}
+	if length := eventMessageLength(ev); limitation.MaxMessageLength > 0 && length > limitation.MaxMessageLength {
+		return fmt.Errorf("%w: message length %d over %d", ErrEventTooLarge, length, limitation.MaxMessageLength)
+	}
+
+	return nil
+}
+
+// eventMessageLength returns the length of the EVENT message that publishes the event.
+func eventMessageLength(ev *goNostr.Event) int {
+	b, err := json.Marshal([]interface{}{"EVENT", ev})
+	if err != nil {
+		return 0
+	}
+
+	return len(b)
+}
+
+// RelayLimits returns the smallest max_message_length and max_content_length among the relays of the relay set
+// and relays, zero when none has a limit.
+func (s *svc) RelayLimits(ctx context.Context, relays ...string) (int, int) {
+	urls := make([]string, 0, len(relays))
+	for _, status := range s.pool.statuses() {
+		urls = append(urls, status.URL)
+	}
+	urls = append(urls, relays...)
+
+	infos := make([]*nip11.RelayInformationDocument, len(urls))
+	var wg sync.WaitGroup
+	for i := range urls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			infos[i] = s.relayInfos.get(ctx, urls[i])
+		}(i)
+	}
+	wg.Wait()
+
+	maxMessage, maxContent := 0, 0
+	for _, info := range infos {
+		if info == nil || info.Limitation == nil {
+			continue
+		}
+		if l := info.Limitation.MaxMessageLength; l > 0 && (maxMessage == 0 || l < maxMessage) {
+			maxMessage = l
+		}
+		if l := info.Limitation.MaxContentLength; l > 0 && (maxContent == 0 || l < maxContent) {
+			maxContent = l
+		}
+	}
+
+	return maxMessage, maxContent
+}
+
+// SetRelayPolicy sets which relays outside the relay set are used.
+func (s *svc) SetRelayPolicy(p RelayPolicy) {
+	s.relayInfos.setPolicy(p)
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
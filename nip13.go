@@ -0,0 +1,201 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+const defaultPowTimeout = 10 * time.Second
+
+var (
+	ErrPowTimeout = errors.New("proof of work not found in time")
+)
+
+type PowConfig struct {
+	// Difficulty is the number of leading zero bits of the IDs of the feedback, result and NIP-89 events the engine
+	// publishes.
+	Difficulty int
+
+	// Timeout bounds the time spent mining an event, waiting for a free CPU included. Events not mined in time are
+	// published without proof of work. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// EnablePowMining makes the engine mine NIP-13 proof of work on the feedback, result and NIP-89 events it
+// publishes, so they are accepted by relays that require it. At most one event per CPU is mined at once.
+//
+// The feedback and result events of a job are mined, signed and published in order on a goroutine of their own, so
+// the job keeps receiving the updates of its DVM meanwhile. Each event can be delayed by up to PowConfig.Timeout, and
+// failures to publish them are only logged. Every extra bit of difficulty doubles the expected mining time.
+func (e *Engine) EnablePowMining(cfg PowConfig) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultPowTimeout
+	}
+	e.pow = &cfg
+	e.powSlots = make(chan struct{}, runtime.NumCPU())
+	e.powQueues = make(map[powQueueKey][]func())
+}
+
+// WithMinPow makes the DVM ignore job requests with less than difficulty bits of NIP-13 proof of work, or that
+// committed to a lower target in their nonce tag.
+func WithMinPow(difficulty int) DvmOption {
+	return func(c *dvmConfig) {
+		c.minPow = difficulty
+	}
+}
+
+// WithLowPowDelay makes the DVM serve the job requests below its minimum proof of work after delay, instead of
+// ignoring them. See WithMinPow.
+func WithLowPowDelay(delay time.Duration) DvmOption {
+	return func(c *dvmConfig) {
+		c.lowPowDelay = delay
+	}
+}
+
+// powDelay returns how long the DVM waits before serving the job request, and false when it must ignore it.
+func (e *Engine) powDelay(dvm Dvmer, event *goNostr.Event) (time.Duration, bool) {
+	cfg, ok := e.dvmConfigs[dvm.PublicKeyHex()]
+	if !ok || cfg.minPow <= 0 || hasPow(event, cfg.minPow) {
+		return 0, true
+	}
+
+	return cfg.lowPowDelay, cfg.lowPowDelay > 0
+}
+
+// hasPow reports whether the ID of the event has at least difficulty leading zero bits, and its nonce tag, if any,
+// doesn't commit to a lower target.
+func hasPow(event *goNostr.Event, difficulty int) bool {
+	if nip13.Difficulty(event.GetID()) < difficulty {
+		return false
+	}
+
+	nonce := event.Tags.GetFirst([]string{"nonce", ""})
+	if nonce == nil || len(*nonce) < 3 {
+		return true
+	}
+	target, err := strconv.Atoi((*nonce)[2])
+
+	return err == nil && target >= difficulty
+}
+
+// signEvent signs the event with the DVM, after mining proof of work on it when enabled. Events that can't be mined
+// in time are signed without it.
+func (e *Engine) signEvent(ctx context.Context, dvm Dvmer, ev *goNostr.Event) error {
+	if e.pow != nil && e.pow.Difficulty > 0 {
+		ev.PubKey = dvm.PublicKeyHex()
+		if err := e.minePow(ctx, ev); err != nil {
+			e.log.Printf("mine proof of work for event kind %d %+v", ev.Kind, err)
+		}
+	}
+
+	return dvm.Sign(ev)
+}
+
+// powQueueKey identifies the events of the job run by one DVM.
+type powQueueKey struct {
+	input *Nip90Input
+	dvm   string
+}
+
+// signAndPublish signs the job event with the DVM and publishes it with publish. When proof of work mining is
+// enabled, the event is mined, signed and published later, after the previous events of the job, and nil is
+// returned right away.
+func (e *Engine) signAndPublish(
+	ctx context.Context,
+	dvm Dvmer,
+	input *Nip90Input,
+	ev *goNostr.Event,
+	publish func() error,
+) error {
+	if e.pow == nil || e.pow.Difficulty <= 0 {
+		if err := dvm.Sign(ev); err != nil {
+			return err
+		}
+
+		return publish()
+	}
+
+	key := powQueueKey{input: input, dvm: dvm.PublicKeyHex()}
+	task := func() {
+		if err := e.signEvent(ctx, dvm, ev); err != nil {
+			e.log.Printf("sign event kind %d of job %s %+v", ev.Kind, input.JobRequestId, err)
+			return
+		}
+		if err := publish(); err != nil {
+			e.log.Printf("publish event kind %d of job %s %+v", ev.Kind, input.JobRequestId, err)
+		}
+	}
+
+	e.powMu.Lock()
+	queue, running := e.powQueues[key]
+	e.powQueues[key] = append(queue, task)
+	e.powMu.Unlock()
+
+	if !running {
+		go e.runPowQueue(key)
+	}
+
+	return nil
+}
+
+// runPowQueue runs the tasks queued for the job until there are none left.
+func (e *Engine) runPowQueue(key powQueueKey) {
+	for {
+		e.powMu.Lock()
+		queue := e.powQueues[key]
+		if len(queue) == 0 {
+			delete(e.powQueues, key)
+			e.powMu.Unlock()
+			return
+		}
+		e.powQueues[key] = queue[1:]
+		e.powMu.Unlock()
+
+		queue[0]()
+	}
+}
+
+// minePow sets a nonce tag on the event, replacing any previous one, that gives its ID the configured difficulty.
+// The event is left untouched when it fails.
+func (e *Engine) minePow(ctx context.Context, ev *goNostr.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, e.pow.Timeout)
+	defer cancel()
+
+	select {
+	case e.powSlots <- struct{}{}:
+		defer func() { <-e.powSlots }()
+	case <-ctx.Done():
+		return ErrPowTimeout
+	}
+
+	mined := *ev
+	tag := goNostr.Tag{"nonce", "", strconv.Itoa(e.pow.Difficulty)}
+	mined.Tags = make(goNostr.Tags, 0, len(ev.Tags)+1)
+	for _, t := range ev.Tags {
+		if len(t) == 0 || t[0] != "nonce" {
+			mined.Tags = append(mined.Tags, t)
+		}
+	}
+	mined.Tags = append(mined.Tags, tag)
+
+	for nonce := uint64(1); ; nonce++ {
+		if nonce%1000 == 1 {
+			if ctx.Err() != nil {
+				return ErrPowTimeout
+			}
+			mined.CreatedAt = goNostr.Now()
+		}
+
+		tag[1] = strconv.FormatUint(nonce, 10)
+		if nip13.Difficulty(mined.GetID()) >= e.pow.Difficulty {
+			*ev = mined
+			return nil
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"testing"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+func TestHasPow(t *testing.T) {
+	// mine returns an event whose ID has at least 8 leading zero bits, with a nonce tag committing to target.
+	mine := func(target string) *goNostr.Event {
+		ev := &goNostr.Event{Kind: 5050, CreatedAt: goNostr.Now()}
+		for nonce := 0; ; nonce++ {
+			ev.Tags = goNostr.Tags{{"nonce", strconv.Itoa(nonce)}}
+			if target != "" {
+				ev.Tags[0] = append(ev.Tags[0], target)
+			}
+			if ev.ID = ev.GetID(); nip13.Difficulty(ev.ID) >= 8 {
+				return ev
+			}
+		}
+	}
+
+	tests := []struct {
+		name       string
+		event      *goNostr.Event
+		difficulty int
+		want       bool
+	}{
+		{"without target", mine(""), 8, true},
+		{"with target", mine("8"), 8, true},
+		{"lower target", mine("4"), 8, false},
+		{"invalid target", mine("eight"), 8, false},
+		{"no difficulty required", &goNostr.Event{Kind: 5050}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasPow(tt.event, tt.difficulty); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	ev := mine("")
+	if hasPow(ev, nip13.Difficulty(ev.ID)+1) {
+		t.Error("got proof of work above the difficulty of the ID")
+	}
+}
+
+func TestMinePow(t *testing.T) {
+	e := &Engine{}
+	e.EnablePowMining(PowConfig{Difficulty: 8})
+
+	ev := &goNostr.Event{
+		Kind:      KindJobFeedback,
+		CreatedAt: goNostr.Now(),
+		Tags:      goNostr.Tags{{"e", "request"}, {"nonce", "1", "4"}},
+	}
+	if err := e.minePow(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasPow(ev, 8) {
+		t.Errorf("event %s has no proof of work", ev.GetID())
+	}
+	if len(ev.Tags) != 2 || ev.Tags[0][0] != "e" || ev.Tags[1][0] != "nonce" || ev.Tags[1][2] != "8" {
+		t.Errorf("unexpected tags %v", ev.Tags)
+	}
+}
+
+func TestMinePowTimeout(t *testing.T) {
+	e := &Engine{}
+	e.EnablePowMining(PowConfig{Difficulty: 200, Timeout: 10 * time.Millisecond})
+
+	ev := &goNostr.Event{Kind: KindJobFeedback, CreatedAt: 1, Tags: goNostr.Tags{{"e", "request"}}}
+	if err := e.minePow(context.Background(), ev); !errors.Is(err, ErrPowTimeout) {
+		t.Fatalf("got error %v, want %v", err, ErrPowTimeout)
+	}
+	if ev.CreatedAt != 1 || len(ev.Tags) != 1 {
+		t.Errorf("event changed after failing: %+v", ev)
+	}
+}
+
+// gatedDvm signs events once they are released.
+type gatedDvm struct {
+	Dvmer
+	release chan struct{}
+}
+
+func (d *gatedDvm) PublicKeyHex() string {
+	return "dvm"
+}
+
+func (d *gatedDvm) Sign(ev *goNostr.Event) error {
+	<-d.release
+	return nil
+}
+
+func TestSignAndPublishMinesInTheBackground(t *testing.T) {
+	e := &Engine{log: log.New(io.Discard, "", 0)}
+	e.EnablePowMining(PowConfig{Difficulty: 1})
+
+	dvm := &gatedDvm{release: make(chan struct{})}
+	input := &Nip90Input{JobRequestId: "request"}
+	published := make(chan int, 2)
+	kinds := []int{KindJobFeedback, 6000}
+
+	// neither call waits for the events to be signed.
+	for _, kind := range kinds {
+		ev := &goNostr.Event{Kind: kind, Tags: goNostr.Tags{{"e", "request"}}}
+		if err := e.signAndPublish(context.Background(), dvm, input, ev, func() error {
+			published <- ev.Kind
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range kinds {
+		dvm.release <- struct{}{}
+		if kind := <-published; kind != want {
+			t.Errorf("published event kind %d, want %d", kind, want)
+		}
+	}
+}
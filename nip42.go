@@ -0,0 +1,98 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+// authRequiredPrefix starts the reason of CLOSED and OK messages of relays that require NIP-42 authentication.
+const authRequiredPrefix = "auth-required:"
+
+var (
+	ErrNoAuthSigner = errors.New("no identity to authenticate with the relay")
+)
+
+// Signer signs events on behalf of a nostr identity. Every Dvmer is a Signer.
+type Signer interface {
+	PublicKeyHex() string
+	Sign(e *goNostr.Event) error
+}
+
+// SetIdentity sets the identity the engine authenticates with on relays that require NIP-42 AUTH to read job
+// requests. Defaults to the first registered DVM. Events are always published authenticated as their author.
+func (e *Engine) SetIdentity(signer Signer) {
+	e.identity = signer
+}
+
+// authSigner returns the DVM with the public key, or the engine identity when pubkey is empty or unknown.
+func (e *Engine) authSigner(pubkey string) Signer {
+	if pubkey != "" {
+		if dvm := e.dvmByPubkey(pubkey); dvm != nil {
+			return dvm
+		}
+	}
+
+	return e.identity
+}
+
+func isAuthRequired(reason string) bool {
+	return strings.Contains(reason, authRequiredPrefix)
+}
+
+// setAuthenticator sets the function that returns the identity to authenticate as, given the public key of the
+// event being published, or an empty one for subscriptions.
+func (p *relayPool) setAuthenticator(signerFor func(pubkey string) Signer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.signerFor = signerFor
+}
+
+// authenticate answers the last AUTH challenge of the relay as pubkey.
+func (p *relayPool) authenticate(ctx context.Context, relay *goNostr.Relay, pubkey string) error {
+	p.mu.Lock()
+	signerFor := p.signerFor
+	p.mu.Unlock()
+
+	if signerFor == nil {
+		return ErrNoAuthSigner
+	}
+	signer := signerFor(pubkey)
+	if signer == nil {
+		return ErrNoAuthSigner
+	}
+
+	return relay.Auth(ctx, func(e *goNostr.Event) error {
+		e.PubKey = signer.PublicKeyHex()
+		return signer.Sign(e)
+	})
+}
+
+// publish publishes the event to the relay. Relays that require authentication are answered as the event author
+// and the event is published again.
+func (p *relayPool) publish(ctx context.Context, relay *goNostr.Relay, e goNostr.Event) error {
+	err := publishEvent(ctx, relay, e)
+	if err == nil || !isAuthRequired(err.Error()) {
+		return err
+	}
+
+	if authErr := p.authenticate(ctx, relay, e.PubKey); authErr != nil {
+		return errors.Join(err, authErr)
+	}
+
+	return publishEvent(ctx, relay, e)
+}
+
+// publishEvent publishes the event and waits for the relay to accept it. The connection dropping before the relay
+// answered is an error, which relay.Publish doesn't report.
+func publishEvent(ctx context.Context, relay *goNostr.Relay, e goNostr.Event) error {
+	err := relay.Publish(ctx, e)
+	if err == nil && relay.Context().Err() != nil {
+		return errRelayConnectionClosed
+	}
+
+	return err
+}
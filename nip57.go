@@ -0,0 +1,84 @@
+package godvm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/sebdeveloper6952/godvm/lightning"
+)
+
+const (
+	KindZapRequest = 9734
+	KindZapReceipt = 9735
+)
+
+var (
+	ErrInvalidZapReceipt = errors.New("invalid zap receipt")
+)
+
+// Zap is a payment proven by a NIP-57 zap receipt.
+type Zap struct {
+	SenderPubkey    string
+	RecipientPubkey string
+	AmountMsats     int64
+	PaymentHash     string
+	Receipt         *goNostr.Event
+}
+
+// ZapFromReceipt validates a kind 9735 zap receipt and returns the zap it proves. The receipt and its embedded zap
+// request must be correctly signed, zap the same recipient, and the bolt11 invoice must commit to the zap request.
+func ZapFromReceipt(receipt *goNostr.Event) (*Zap, error) {
+	if receipt.Kind != KindZapReceipt || !authentic(receipt) {
+		return nil, ErrInvalidZapReceipt
+	}
+
+	bolt11 := receipt.Tags.GetFirst([]string{"bolt11", ""})
+	description := receipt.Tags.GetFirst([]string{"description", ""})
+	recipient := receipt.Tags.GetFirst([]string{"p", ""})
+	if bolt11 == nil || description == nil || recipient == nil {
+		return nil, ErrInvalidZapReceipt
+	}
+
+	zapRequest := &goNostr.Event{}
+	if err := json.Unmarshal([]byte(description.Value()), zapRequest); err != nil {
+		return nil, ErrInvalidZapReceipt
+	}
+	if zapRequest.Kind != KindZapRequest {
+		return nil, ErrInvalidZapReceipt
+	}
+	if ok, _ := zapRequest.CheckSignature(); !ok {
+		return nil, ErrInvalidZapReceipt
+	}
+	requested := zapRequest.Tags.GetFirst([]string{"p", ""})
+	if requested == nil || requested.Value() != recipient.Value() {
+		return nil, ErrInvalidZapReceipt
+	}
+
+	invoice, err := lightning.DecodePayReq(bolt11.Value())
+	if err != nil || invoice.MilliSat == nil || invoice.PaymentHash == nil || invoice.DescriptionHash == nil {
+		return nil, ErrInvalidZapReceipt
+	}
+	if *invoice.DescriptionHash != sha256.Sum256([]byte(description.Value())) {
+		return nil, ErrInvalidZapReceipt
+	}
+
+	amountMsats := int64(*invoice.MilliSat)
+	if amountTag := zapRequest.Tags.GetFirst([]string{"amount", ""}); amountTag != nil {
+		requested, err := strconv.ParseInt(amountTag.Value(), 10, 64)
+		if err != nil || requested != amountMsats {
+			return nil, ErrInvalidZapReceipt
+		}
+	}
+
+	return &Zap{
+		SenderPubkey:    zapRequest.PubKey,
+		RecipientPubkey: recipient.Value(),
+		AmountMsats:     amountMsats,
+		PaymentHash:     hex.EncodeToString(invoice.PaymentHash[:]),
+		Receipt:         receipt,
+	}, nil
+}
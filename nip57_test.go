@@ -0,0 +1,150 @@
+package godvm
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+// zapper signs zap requests as a sender and zap receipts as an LNURL server.
+type zapper struct {
+	t       *testing.T
+	sender  string
+	zapper  string
+	nodeKey *btcec.PrivateKey
+}
+
+func newZapper(t *testing.T) *zapper {
+	nodeKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &zapper{
+		t:       t,
+		sender:  goNostr.GeneratePrivateKey(),
+		zapper:  goNostr.GeneratePrivateKey(),
+		nodeKey: nodeKey,
+	}
+}
+
+// request returns a zap request to the recipient, with an amount tag unless it's empty.
+func (z *zapper) request(recipient string, amountTag string) string {
+	request := &goNostr.Event{
+		Kind:      KindZapRequest,
+		CreatedAt: goNostr.Now(),
+		Tags:      goNostr.Tags{{"p", recipient}},
+	}
+	if amountTag != "" {
+		request.Tags = append(request.Tags, goNostr.Tag{"amount", amountTag})
+	}
+	if err := request.Sign(z.sender); err != nil {
+		z.t.Fatal(err)
+	}
+
+	return request.String()
+}
+
+// bolt11 returns an invoice of the amount committing to the description.
+func (z *zapper) bolt11(amountMsats int64, description string) string {
+	invoice, err := zpay32.NewInvoice(
+		&chaincfg.MainNetParams,
+		[32]byte{1},
+		time.Now(),
+		zpay32.Amount(lnwire.MilliSatoshi(amountMsats)),
+		zpay32.DescriptionHash(sha256.Sum256([]byte(description))),
+	)
+	if err != nil {
+		z.t.Fatal(err)
+	}
+	encoded, err := invoice.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return ecdsa.SignCompact(z.nodeKey, chainhash.HashB(msg), true)
+		},
+	})
+	if err != nil {
+		z.t.Fatal(err)
+	}
+
+	return encoded
+}
+
+// receipt returns a receipt of the zap request to the recipient, paid with the invoice.
+func (z *zapper) receipt(kind int, recipient string, bolt11 string, request string) *goNostr.Event {
+	receipt := &goNostr.Event{
+		Kind:      kind,
+		CreatedAt: goNostr.Now(),
+		Tags: goNostr.Tags{
+			{"p", recipient},
+			{"bolt11", bolt11},
+			{"description", request},
+		},
+	}
+	if err := receipt.Sign(z.zapper); err != nil {
+		z.t.Fatal(err)
+	}
+
+	return receipt
+}
+
+func TestZapFromReceipt(t *testing.T) {
+	z := newZapper(t)
+	senderPubkey, _ := goNostr.GetPublicKey(z.sender)
+
+	request := z.request("dvm", "21000")
+	requestWithoutAmount := z.request("dvm", "")
+	requestToOther := z.request("other", "21000")
+	tampered := z.receipt(KindZapReceipt, "dvm", z.bolt11(21000, request), request)
+	tampered.Tags = append(tampered.Tags, goNostr.Tag{"extra"})
+
+	tests := []struct {
+		name    string
+		receipt *goNostr.Event
+		wantErr error
+	}{
+		{"valid", z.receipt(KindZapReceipt, "dvm", z.bolt11(21000, request), request), nil},
+		{
+			"without amount tag",
+			z.receipt(KindZapReceipt, "dvm", z.bolt11(5000, requestWithoutAmount), requestWithoutAmount),
+			nil,
+		},
+		{"not a receipt", z.receipt(1, "dvm", z.bolt11(21000, request), request), ErrInvalidZapReceipt},
+		{"tampered receipt", tampered, ErrInvalidZapReceipt},
+		{"amount mismatch", z.receipt(KindZapReceipt, "dvm", z.bolt11(1000, request), request), ErrInvalidZapReceipt},
+		{
+			"description mismatch",
+			z.receipt(KindZapReceipt, "dvm", z.bolt11(21000, "other"), request),
+			ErrInvalidZapReceipt,
+		},
+		{
+			"recipient mismatch",
+			z.receipt(KindZapReceipt, "dvm", z.bolt11(21000, requestToOther), requestToOther),
+			ErrInvalidZapReceipt,
+		},
+		{"invalid zap request", z.receipt(KindZapReceipt, "dvm", z.bolt11(21000, "{}"), "{}"), ErrInvalidZapReceipt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zap, err := ZapFromReceipt(tt.receipt)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if zap.SenderPubkey != senderPubkey || zap.RecipientPubkey != "dvm" || zap.AmountMsats <= 0 {
+				t.Errorf("unexpected zap %+v", zap)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	KindRelayList = 10002
+
+	// relayListTTL is how long a customer relay list is cached, including the fact that they have none.
+	relayListTTL = 30 * time.Minute
+
+	// maxOutboxRelays caps the customer read relays feedback and results are published to.
+	maxOutboxRelays = 5
+)
+
+// NewRelayListEvent returns a NIP-65 relay list where every relay is used both to read and write.
+func NewRelayListEvent(pk string, relays []string) *goNostr.Event {
+	e := &goNostr.Event{
+		PubKey:    pk,
+		CreatedAt: goNostr.Now(),
+		Kind:      KindRelayList,
+		Tags:      make(goNostr.Tags, 0, len(relays)),
+	}
+
+	for i := range relays {
+		e.Tags = append(e.Tags, goNostr.Tag{"r", relays[i]})
+	}
+
+	return e
+}
+
+// ReadRelaysFromRelayList returns the relays of a NIP-65 relay list its author reads from, i.e. those marked as
+// read or not marked at all.
+func ReadRelaysFromRelayList(e *goNostr.Event) []string {
+	relays := make([]string, 0, len(e.Tags))
+	for _, tag := range e.Tags {
+		if len(tag) < 2 || tag[0] != "r" {
+			continue
+		}
+		if len(tag) > 2 && tag[2] != "read" {
+			continue
+		}
+		relays = append(relays, tag[1])
+	}
+
+	return relays
+}
+
+type relayListEntry struct {
+	readRelays []string
+	fetchedAt  time.Time
+}
+
+// relayListCache keeps the read relays of customers, see relayListTTL.
+type relayListCache struct {
+	mu      sync.Mutex
+	entries map[string]*relayListEntry
+}
+
+func newRelayListCache() *relayListCache {
+	return &relayListCache{
+		entries: make(map[string]*relayListEntry),
+	}
+}
+
+func (c *relayListCache) get(pubkey string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[pubkey]
+	if !ok || time.Since(entry.fetchedAt) > relayListTTL {
+		return nil, false
+	}
+
+	return entry.readRelays, true
+}
+
+func (c *relayListCache) put(pubkey string, readRelays []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.Sub(entry.fetchedAt) > relayListTTL {
+			delete(c.entries, key)
+		}
+	}
+
+	c.entries[pubkey] = &relayListEntry{
+		readRelays: readRelays,
+		fetchedAt:  now,
+	}
+}
+
+// customerRelays returns the relays feedback and results of the job are published to besides the engine relays:
+// the relays in the job request `relays` tag and the read relays of the customer NIP-65 list.
+func (e *Engine) customerRelays(ctx context.Context, input *Nip90Input) []string {
+	relays := make([]string, 0, len(input.Relays)+maxOutboxRelays)
+	relays = append(relays, input.Relays...)
+
+	readRelays, ok := e.relayLists.get(input.CustomerPubkey)
+	if !ok {
+		relayList, err := e.nostrSvc.FetchLatestEvent(
+			ctx,
+			goNostr.Filter{
+				Kinds:   []int{KindRelayList},
+				Authors: []string{input.CustomerPubkey},
+				Limit:   1,
+			},
+			input.Relays...,
+		)
+		switch {
+		case err == nil:
+			readRelays = ReadRelaysFromRelayList(relayList)
+			e.relayLists.put(input.CustomerPubkey, readRelays)
+		case errors.Is(err, ErrEventNotFound):
+			e.relayLists.put(input.CustomerPubkey, nil)
+		default:
+			e.log.Printf("fetch relay list of %s %+v", input.CustomerPubkey, err)
+		}
+	}
+
+	if len(readRelays) > maxOutboxRelays {
+		readRelays = readRelays[:maxOutboxRelays]
+	}
+
+	return append(relays, readRelays...)
+}
+
+// relayURLs returns the URLs of every relay of the engine.
+func (e *Engine) relayURLs() []string {
+	statuses := e.nostrSvc.Relays()
+	urls := make([]string, 0, len(statuses))
+	for i := range statuses {
+		urls = append(urls, statuses[i].URL)
+	}
+
+	return urls
+}
@@ -39,6 +39,10 @@ type Input struct {
 	Relay  string
 	Marker string
 	Event  *goNostr.Event
+
+	// Coordinate is set when the input is an event given by coordinate or naddr, in which case Event is its latest
+	// version.
+	Coordinate *Coordinate
 }
 
 type Nip90Input struct {
@@ -55,6 +59,17 @@ type Nip90Input struct {
 	TaggedPubkeys       map[string]struct{}
 }
 
+// Param returns the value of the first param tag with the given name.
+func (n *Nip90Input) Param(name string) (string, bool) {
+	for i := range n.Params {
+		if n.Params[i][0] == name {
+			return n.Params[i][1], true
+		}
+	}
+
+	return "", false
+}
+
 func Nip90InputFromJobRequestEvent(e *goNostr.Event) (*Nip90Input, error) {
 	input := &Nip90Input{
 		JobRequestId:   e.ID,
@@ -98,9 +113,11 @@ func Nip90InputFromJobRequestEvent(e *goNostr.Event) (*Nip90Input, error) {
 			} else if e.Tags[i][0] == "bid" {
 				bidMillisats, err := strconv.Atoi(e.Tags[i][1])
 				if err != nil {
-					return nil, err
+					return nil, fmt.Errorf("%w: bid %q is not an amount of millisats", ErrInvalidJobRequest, e.Tags[i][1])
 				}
 				input.BidMillisats = bidMillisats
+			} else if e.Tags[i][0] == "relays" {
+				input.Relays = append(input.Relays, e.Tags[i][1:]...)
 			} else if e.Tags[i][0] == "p" && len(e.Tags[i]) == 2 {
 				input.TaggedPubkeys[e.Tags[i][1]] = struct{}{}
 			}
@@ -120,10 +137,15 @@ func Nip90JobFeedbackFromEngineUpdate(
 		Tags: goNostr.Tags{
 			{"e", input.JobRequestId},
 			{"p", input.CustomerPubkey},
-			{"status", JobStatusToString[update.Status]},
 		},
 	}
 
+	statusTag := goNostr.Tag{"status", JobStatusToString[update.Status]}
+	if update.FailureMsg != "" {
+		statusTag = append(statusTag, update.FailureMsg)
+	}
+	feedbackEvent.Tags = append(feedbackEvent.Tags, statusTag)
+
 	if update.ExtraTags != nil && len(update.ExtraTags) > 0 {
 		for i := range update.ExtraTags {
 			feedbackEvent.Tags = append(feedbackEvent.Tags, update.ExtraTags[i])
@@ -142,6 +164,17 @@ func Nip90JobFeedbackFromEngineUpdate(
 	return feedbackEvent
 }
 
+// inputTag returns the `i` tag of the input as it was in the job request. Empty fields are kept when a later one is
+// set, so the marker of an input without relay stays in its position.
+func inputTag(input *Input) goNostr.Tag {
+	tag := goNostr.Tag{"i", input.Value, input.Type, input.Relay, input.Marker}
+	for len(tag) > 2 && tag[len(tag)-1] == "" {
+		tag = tag[:len(tag)-1]
+	}
+
+	return tag
+}
+
 func Nip90JobResultFromEngineUpdate(
 	input *Nip90Input,
 	update *JobUpdate,
@@ -164,24 +197,7 @@ func Nip90JobResultFromEngineUpdate(
 	}
 
 	for i := range input.Inputs {
-		tag := goNostr.Tag{
-			"i",
-			input.Inputs[i].Value,
-		}
-
-		if input.Inputs[i].Type != "" {
-			tag = append(tag, input.Inputs[i].Type)
-		}
-
-		if input.Inputs[i].Relay != "" {
-			tag = append(tag, input.Inputs[i].Relay)
-		}
-
-		if input.Inputs[i].Marker != "" {
-			tag = append(tag, input.Inputs[i].Marker)
-		}
-
-		jobResultEvent.Tags = append(jobResultEvent.Tags, tag)
+		jobResultEvent.Tags = append(jobResultEvent.Tags, inputTag(input.Inputs[i]))
 	}
 
 	if update.Status == StatusSuccessWithPayment && update.PaymentRequest != "" {
@@ -2,14 +2,42 @@ package godvm
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
 
 	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/sebdeveloper6952/godvm/checkpoints"
+	"github.com/sebdeveloper6952/godvm/dedup"
 )
 
+const (
+	fetchTimeout   = 10 * time.Second
+	publishTimeout = 10 * time.Second
+
+	defaultPublishQuorum = 1
+)
+
+var (
+	ErrEventNotFound = errors.New("event not found")
+	ErrPublishQuorum = errors.New("event was not accepted by enough relays")
+
+	errRelayNotConnected = errors.New("relay is not connected")
+)
+
+// PublishResult is the answer of a relay to a published event.
+type PublishResult struct {
+	Relay string
+
+	// Err is nil when the relay accepted the event. Otherwise it's the reason the relay rejected it, or why it
+	// couldn't be reached.
+	Err error
+}
+
 type NostrService interface {
 	Run(
 		ctx context.Context,
@@ -17,25 +45,57 @@ type NostrService interface {
 		initialRelays []string,
 	) error
 	JobRequestEvents() chan *goNostr.Event
+	JobRequestHandled(event *goNostr.Event)
 	InputEvents() chan *goNostr.Event
 	PublishEvent(
 		ctx context.Context,
 		e goNostr.Event,
 		additionalRelays ...string,
-	) error
+	) ([]PublishResult, error)
 	FetchEvent(
 		ctx context.Context,
 		id string,
 		additionalRelays ...string,
+	) (*goNostr.Event, error)
+	FetchLatestEvent(
+		ctx context.Context,
+		filter goNostr.Filter,
+		additionalRelays ...string,
+	) (*goNostr.Event, error)
+	Subscribe(
+		ctx context.Context,
+		filters goNostr.Filters,
 	) (chan *goNostr.Event, error)
+	Relays() []RelayStatus
+	AddRelay(ctx context.Context, url string) error
+	RemoveRelay(url string) error
+	SetAuthenticator(signerFor func(pubkey string) Signer)
+	SetDedup(c *dedup.Cache)
+	SetCatchUp(store checkpoints.Store, lookback time.Duration)
+	PublishEventTo(
+		ctx context.Context,
+		e goNostr.Event,
+		relays ...string,
+	) ([]PublishResult, error)
+	SetPublishQuorum(n int)
+	SetRelayPolicy(p RelayPolicy)
+	RelayLimits(ctx context.Context, relays ...string) (int, int)
 }
 
 type svc struct {
-	relays           []*goNostr.Relay
+	mu               sync.Mutex
+	quorum           int
+	pool             *relayPool
 	jobRequestEvents chan *goNostr.Event
 	inputEvents      chan *goNostr.Event
 	supportedKinds   []int
-	seenEvents       map[string]struct{}
+	dedup            *dedup.Cache
+	fetched          *eventCache
+	ephemeral        *ephemeralPool
+	relayInfos       *relayInfos
+	checkpoints      checkpoints.Store
+	lookback         time.Duration
+	requests         *requestTracker
 	log              *log.Logger
 }
 
@@ -45,7 +105,13 @@ func NewNostrService(
 	return &svc{
 		jobRequestEvents: make(chan *goNostr.Event),
 		inputEvents:      make(chan *goNostr.Event),
-		seenEvents:       make(map[string]struct{}),
+		dedup:            dedup.NewMemory(0, 0),
+		fetched:          newEventCache(),
+		ephemeral:        newEphemeralPool(),
+		relayInfos:       newRelayInfos(),
+		requests:         newRequestTracker(),
+		quorum:           defaultPublishQuorum,
+		pool:             newRelayPool(log),
 		log:              log,
 	}, nil
 }
@@ -61,58 +127,169 @@ func (s *svc) Run(
 		return errors.New("must provide at least one relay")
 	}
 
-	s.relays = make([]*goNostr.Relay, 0, len(initialRelays))
+	s.pool.start(ctx)
+	s.ephemeral.start(ctx)
+
+	ready := make([]<-chan struct{}, 0, len(initialRelays))
 	for i := range initialRelays {
-		relay, err := goNostr.RelayConnect(ctx, initialRelays[i])
+		_, relayReady, err := s.pool.add(initialRelays[i])
 		if err != nil {
-			s.log.Printf("could not connect to relay %s", initialRelays[i])
-			continue
+			return err
 		}
-		s.relays = append(s.relays, relay)
+		ready = append(ready, relayReady)
+	}
+	for i := range ready {
+		<-ready[i]
 	}
 
-	go func() {
-		var (
-			now                     = goNostr.Timestamp(time.Now().Unix())
-			filters goNostr.Filters = []goNostr.Filter{
-				{
-					Kinds: s.supportedKinds,
-					Since: &now,
-				},
+	filter := goNostr.Filter{
+		Kinds: s.supportedKinds,
+	}
+	var since func(relayURL string) time.Time
+	if s.checkpoints != nil {
+		since = s.catchUpSince(time.Now())
+	} else {
+		now := goNostr.Now()
+		filter.Since = &now
+	}
+
+	s.pool.subscribeSince(
+		ctx,
+		goNostr.Filters{filter},
+		since,
+		func(relayURL string, event *goNostr.Event) {
+			if !authentic(event) {
+				s.log.Printf("relay %s sent job request %s with invalid id or signature", relayURL, event.ID)
+				return
 			}
-		)
+			seen := s.dedup.Seen("request:"+event.ID, relayURL)
+			if s.checkpoints != nil {
+				s.requests.received(relayURL, event, !seen)
+			}
+			if seen {
+				return
+			}
+			s.log.Printf("received event %+v\n", event)
 
-		for i := range s.relays {
-			go func(relay *goNostr.Relay) {
-				sub, err := relay.Subscribe(ctx, filters)
-				if err != nil {
-					s.log.Printf("%+v\n", err)
-					return
-				}
-
-				for {
-					select {
-					case event := <-sub.Events:
-						if event != nil {
-							if _, exist := s.seenEvents[event.ID]; exist {
-								continue
-							}
-							s.log.Printf("received event %+v\n", event)
-							s.seenEvents[event.ID] = struct{}{}
-							s.jobRequestEvents <- event
-						}
-					case <-ctx.Done():
-						return
-					}
-				}
-			}(s.relays[i])
+			select {
+			case s.jobRequestEvents <- event:
+			case <-ctx.Done():
+			}
+		},
+		s.relayLive,
+	)
 
+	return nil
+}
+
+// Relays returns the connection state of every relay.
+func (s *svc) Relays() []RelayStatus {
+	return s.pool.statuses()
+}
+
+// AddRelay adds the relay to the pool and opens every subscription on it. It waits for the first connection
+// attempt, and returns its error if it failed: the relay is kept in the pool and retried anyway.
+func (s *svc) AddRelay(ctx context.Context, url string) error {
+	url, ready, err := s.pool.add(url)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, status := range s.pool.statuses() {
+		if status.URL == url && status.State != RelayStateConnected {
+			return status.LastError
 		}
-	}()
+	}
 
 	return nil
 }
 
+// SetAuthenticator sets the identities used to answer NIP-42 AUTH challenges. signerFor gets the author of the
+// event being published, or an empty public key for subscriptions.
+func (s *svc) SetAuthenticator(signerFor func(pubkey string) Signer) {
+	s.pool.setAuthenticator(signerFor)
+}
+
+// SetCatchUp makes Run fetch the job requests published while the service was not running. Each relay is asked
+// for the requests since its checkpoint in store, up to lookback ago. Relays without a checkpoint are only asked for
+// new requests. A checkpoint only moves once the relay sent all its stored requests, and never past a request that
+// is not handled yet, see JobRequestHandled. Catching up with NIP-77 negentropy is not supported.
+func (s *svc) SetCatchUp(store checkpoints.Store, lookback time.Duration) {
+	s.checkpoints = store
+	s.lookback = lookback
+}
+
+// catchUpSince returns when the job request subscription starts on each relay.
+func (s *svc) catchUpSince(startedAt time.Time) func(relayURL string) time.Time {
+	return func(relayURL string) time.Time {
+		checkpoint, err := s.checkpoints.Get(context.Background(), relayURL)
+		if err != nil {
+			s.log.Printf("get checkpoint of relay %s %+v", relayURL, err)
+			return startedAt
+		}
+		if checkpoint.IsZero() {
+			return startedAt
+		}
+
+		return maxTime(checkpoint, startedAt.Add(-s.lookback))
+	}
+}
+
+// JobRequestHandled tells the service the job request was served, rejected or skipped, so the checkpoints of the
+// relays that sent it can move past it.
+func (s *svc) JobRequestHandled(event *goNostr.Event) {
+	if s.checkpoints == nil {
+		return
+	}
+
+	for relayURL, checkpoint := range s.requests.handled(event.ID) {
+		s.advanceCheckpoint(relayURL, checkpoint)
+	}
+}
+
+// relayLive is called when the relay is done sending its stored job requests, and when the subscription goes down
+// on it.
+func (s *svc) relayLive(relayURL string, live bool) {
+	if s.checkpoints == nil {
+		return
+	}
+
+	if checkpoint, ok := s.requests.setLive(relayURL, live); ok {
+		s.advanceCheckpoint(relayURL, checkpoint)
+	}
+}
+
+func (s *svc) advanceCheckpoint(relayURL string, checkpoint time.Time) {
+	if err := s.checkpoints.Advance(context.Background(), relayURL, checkpoint); err != nil {
+		s.log.Printf("advance checkpoint of relay %s %+v", relayURL, err)
+	}
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// SetDedup replaces the cache that drops the duplicates of job requests and subscription events. It must be called
+// before Run.
+func (s *svc) SetDedup(c *dedup.Cache) {
+	s.dedup = c
+}
+
+// RemoveRelay closes the connection to the relay, and its subscriptions.
+func (s *svc) RemoveRelay(url string) error {
+	return s.pool.remove(url)
+}
+
 func (s *svc) JobRequestEvents() chan *goNostr.Event {
 	return s.jobRequestEvents
 }
@@ -121,97 +298,223 @@ func (s *svc) InputEvents() chan *goNostr.Event {
 	return s.inputEvents
 }
 
+// PublishEvent publishes the event to every relay of the pool and to additionalRelays, in parallel, and returns the
+// answer of each one. It fails with ErrPublishQuorum when fewer relays than the quorum accepted the event.
 func (s *svc) PublishEvent(
 	ctx context.Context,
 	e goNostr.Event,
 	additionalRelays ...string,
-) error {
+) ([]PublishResult, error) {
 	s.log.Printf("publish event %+v\n", e)
 
-	for i := range s.relays {
-		if err := s.relays[i].Publish(ctx, e); err != nil {
-			s.log.Printf("publish to relay %s %+v", s.relays[i].URL, err)
+	urls := make([]string, 0)
+	for _, status := range s.pool.statuses() {
+		urls = append(urls, status.URL)
+	}
+
+	return s.publish(ctx, e, append(urls, additionalRelays...))
+}
+
+// PublishEventTo publishes the event only to the relays, the same way as PublishEvent.
+func (s *svc) PublishEventTo(
+	ctx context.Context,
+	e goNostr.Event,
+	relays ...string,
+) ([]PublishResult, error) {
+	return s.publish(ctx, e, relays)
+}
+
+func (s *svc) publish(ctx context.Context, e goNostr.Event, urls []string) ([]PublishResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	results := make([]PublishResult, 0, len(urls))
+	seen := make(map[string]struct{}, len(urls))
+	for _, url := range urls {
+		url = goNostr.NormalizeURL(url)
+		if _, ok := seen[url]; ok || url == "" {
+			continue
 		}
+		seen[url] = struct{}{}
+		results = append(results, PublishResult{Relay: url})
 	}
 
-	for i := range additionalRelays {
-		go func(url string) {
-			relay, err := goNostr.RelayConnect(ctx, url)
-			if err != nil {
-				s.log.Printf("connect to relay %s %+v", url, err)
-				return
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(result *PublishResult) {
+			defer wg.Done()
+			result.Err = s.publishToRelay(ctx, e, result.Relay)
+			if result.Err != nil {
+				s.log.Printf("publish to relay %s %+v", result.Relay, result.Err)
 			}
-			if err := relay.Publish(ctx, e); err != nil {
-				s.log.Printf("publish to relay %s %+v", url, err)
-			}
-			relay.Close()
-		}(additionalRelays[i])
+		}(&results[i])
 	}
+	wg.Wait()
 
-	return nil
+	return results, s.checkQuorum(results)
+}
+
+// publishToRelay publishes the event with the pool connection to the relay, or with a shared ephemeral connection
+// when the relay is not in the pool. Relays whose NIP-11 document rules out the event are skipped.
+func (s *svc) publishToRelay(ctx context.Context, e goNostr.Event, url string) error {
+	inPool := s.pool.has(url)
+
+	info := s.relayInfos.get(ctx, url)
+	if !inPool {
+		if err := s.relayInfos.allowed(info); err != nil {
+			return err
+		}
+	}
+	if err := accepts(info, &e); err != nil {
+		return err
+	}
+
+	relay, ok := s.pool.relay(url)
+	if !ok {
+		if inPool {
+			return errRelayNotConnected
+		}
+
+		var (
+			release func()
+			err     error
+		)
+		relay, release, err = s.ephemeral.acquire(ctx, url)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	return s.pool.publish(ctx, relay, e)
 }
 
+// checkQuorum returns ErrPublishQuorum, along with the error of every relay, when fewer relays than the quorum
+// accepted the event. The quorum is capped at the number of relays that were not skipped, but there must be at
+// least one.
+func (s *svc) checkQuorum(results []PublishResult) error {
+	accepted, eligible := 0, 0
+	errs := make([]error, 0)
+	for i := range results {
+		if !errors.Is(results[i].Err, ErrRelaySkipped) {
+			eligible++
+		}
+		if results[i].Err == nil {
+			accepted++
+			continue
+		}
+		errs = append(errs, fmt.Errorf("relay %s: %w", results[i].Relay, results[i].Err))
+	}
+
+	s.mu.Lock()
+	quorum := min(s.quorum, eligible)
+	s.mu.Unlock()
+
+	if accepted >= max(quorum, 1) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %d of %d relays accepted it: %w", ErrPublishQuorum, accepted, len(results), errors.Join(errs...))
+}
+
+// SetPublishQuorum sets how many relays must accept an event for PublishEvent to succeed.
+func (s *svc) SetPublishQuorum(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.quorum = n
+}
+
+// FetchEvent returns the event with the ID, from the cache or from the first relay that sends it, among the
+// connected relays and additionalRelays. It returns ErrEventNotFound when no relay has it, and ErrFetchTimeout when
+// it wasn't found before fetchTimeout and some relay didn't answer.
 func (s *svc) FetchEvent(
 	ctx context.Context,
 	id string,
 	additionalRelays ...string,
-) (chan *goNostr.Event, error) {
-	var (
-		filters = []goNostr.Filter{
-			{IDs: []string{id}},
-		}
-		wg                sync.WaitGroup
-		subCtx, cancelCtx = context.WithCancel(ctx)
-		eventCh           = make(chan *goNostr.Event)
-	)
+) (*goNostr.Event, error) {
+	if event, ok := s.fetched.get(id); ok {
+		return event, nil
+	}
 
-	searchRelays := make([]*goNostr.Relay, 0, len(s.relays))
-	searchRelays = append(searchRelays, s.relays...)
+	events, err := s.query(ctx, goNostr.Filter{IDs: []string{id}}, true, additionalRelays...)
+	if err != nil {
+		return nil, err
+	}
+	s.log.Printf("fetched event %s", id)
+	s.fetched.add(events[0])
 
-	for i := range additionalRelays {
-		relay, err := goNostr.RelayConnect(ctx, additionalRelays[i])
-		if err != nil {
-			s.log.Printf("connect/fetch event from relay %s", additionalRelays[i])
-			continue
+	return events[0], nil
+}
+
+// FetchLatestEvent queries every relay for the filter and returns the newest matching event, e.g. the current
+// version of a replaceable event. Errors are the same as FetchEvent's.
+func (s *svc) FetchLatestEvent(
+	ctx context.Context,
+	filter goNostr.Filter,
+	additionalRelays ...string,
+) (*goNostr.Event, error) {
+	events, err := s.query(ctx, filter, false, additionalRelays...)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := events[0]
+	for _, event := range events[1:] {
+		if event.CreatedAt > latest.CreatedAt {
+			latest = event
 		}
-		searchRelays = append(searchRelays, relay)
 	}
 
-	go func() {
-		wg.Add(len(s.relays))
-		for i := range s.relays {
-			go func(relay *goNostr.Relay) {
-				defer func() {
-					wg.Done()
-				}()
-
-				sub, err := relay.Subscribe(subCtx, filters)
-				if err != nil {
-					s.log.Printf("%+v\n", err)
-					return
-				}
-
-				for {
-					select {
-					case event := <-sub.Events:
-						if event != nil {
-							s.log.Printf("received requested event %s %+v\n", relay.URL, event)
-							eventCh <- event
-							sub.Close()
-							cancelCtx()
-							return
-						}
-					case <-subCtx.Done():
-						return
-					}
-				}
-			}(s.relays[i])
+	return latest, nil
+}
+
+// subscriptionScope prefixes the dedup keys of a subscription. Since and Until are left out, so the scope of a
+// subscription opened again after a restart stays the same.
+func subscriptionScope(filters goNostr.Filters) string {
+	scoped := make(goNostr.Filters, len(filters))
+	for i := range filters {
+		scoped[i] = filters[i]
+		scoped[i].Since = nil
+		scoped[i].Until = nil
+	}
+	hash := sha256.Sum256([]byte(scoped.String()))
+
+	return "subscription:" + hex.EncodeToString(hash[:8]) + ":"
+}
+
+// Subscribe opens a subscription on every relay and merges the events into a single channel, without duplicates.
+// The subscription is opened again on relays that reconnect. The channel is closed once ctx is done. Events are
+// deduplicated by filters, so subscriptions with the same filters don't receive the same event twice, even across
+// restarts when the dedup cache is persisted. Events with an invalid ID or signature are dropped.
+func (s *svc) Subscribe(
+	ctx context.Context,
+	filters goNostr.Filters,
+) (chan *goNostr.Event, error) {
+	eventsCh := make(chan *goNostr.Event)
+	scope := subscriptionScope(filters)
+
+	done := s.pool.subscribe(ctx, filters, func(relayURL string, event *goNostr.Event) {
+		// an event with a forged ID would take the place of the genuine one in the dedup cache.
+		if !authentic(event) {
+			s.log.Printf("relay %s sent event %s with invalid id or signature", relayURL, event.ID)
+			return
 		}
+		if s.dedup.Seen(scope+event.ID, relayURL) {
+			return
+		}
+
+		select {
+		case eventsCh <- event:
+		case <-ctx.Done():
+		}
+	})
 
-		wg.Wait()
-		close(eventCh)
-		cancelCtx()
+	go func() {
+		<-done
+		close(eventsCh)
 	}()
 
-	return eventCh, nil
+	return eventsCh, nil
 }
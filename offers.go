@@ -0,0 +1,167 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/sebdeveloper6952/godvm/lightning"
+)
+
+// offerStreamBackoff is how long the engine waits before subscribing again to the offer payments of a backend whose
+// stream failed.
+const offerStreamBackoff = 10 * time.Second
+
+// jobIDPattern finds job request IDs in BOLT12 payer notes.
+var jobIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// offerWait is a job waiting to be paid through the offer of its DVM.
+type offerWait struct {
+	amountSats int64
+	paid       func()
+}
+
+// offerFor returns the offer of the DVM, nil if it doesn't have one.
+func (e *Engine) offerFor(dvm Dvmer) *lightning.Offer {
+	e.offersMu.Lock()
+	defer e.offersMu.Unlock()
+
+	return e.offers[dvm.PublicKeyHex()]
+}
+
+// createOffers creates the offers of the DVMs registered with WithOffer, and listens for the payments made to them.
+// DVMs whose lightning service can't receive BOLT12 payments only take BOLT11 invoices.
+func (e *Engine) createOffers(ctx context.Context) {
+	listening := make(map[lightning.Service]struct{})
+
+	for _, dvms := range e.dvmsByKind {
+		for _, dvm := range dvms {
+			cfg, ok := e.dvmConfigs[dvm.PublicKeyHex()]
+			if !ok || !cfg.offer {
+				continue
+			}
+
+			lnSvc, err := e.lnServiceFor(dvm)
+			if err != nil {
+				e.log.Printf("offer for %s %+v", dvm.PublicKeyHex(), err)
+				continue
+			}
+			offerer, ok := lnSvc.(lightning.Offerer)
+			if !ok {
+				e.log.Printf("offer for %s %+v", dvm.PublicKeyHex(), lightning.ErrOffersNotSupported)
+				continue
+			}
+
+			description := dvm.PublicKeyHex()
+			if profile := dvm.Profile(); profile != nil && profile.Name != "" {
+				description = profile.Name
+			}
+
+			offer, err := offerer.CreateOffer(ctx, description)
+			if err != nil {
+				e.log.Printf("offer for %s %+v", dvm.PublicKeyHex(), err)
+				continue
+			}
+
+			e.offersMu.Lock()
+			e.offers[dvm.PublicKeyHex()] = offer
+			e.offersMu.Unlock()
+
+			if _, ok := listening[lnSvc]; !ok {
+				listening[lnSvc] = struct{}{}
+				go e.listenForOfferPayments(ctx, offerer)
+			}
+		}
+	}
+}
+
+// waitForOfferPayment calls paid once a payment of at least amountSats is made to the offer of the DVM with the job
+// ID in its payer note, unless cancelOfferWait is called first.
+func (e *Engine) waitForOfferPayment(run *jobRun, amountSats int64, paid func()) {
+	offer := e.offerFor(run.dvm)
+	if offer == nil {
+		return
+	}
+
+	e.offersMu.Lock()
+	defer e.offersMu.Unlock()
+
+	e.offerWaits[offer.ID+":"+run.input.JobRequestId] = &offerWait{
+		amountSats: amountSats,
+		paid:       paid,
+	}
+}
+
+func (e *Engine) cancelOfferWait(run *jobRun) {
+	offer := e.offerFor(run.dvm)
+	if offer == nil {
+		return
+	}
+
+	e.offersMu.Lock()
+	defer e.offersMu.Unlock()
+
+	delete(e.offerWaits, offer.ID+":"+run.input.JobRequestId)
+}
+
+func (e *Engine) listenForOfferPayments(ctx context.Context, offerer lightning.Offerer) {
+	for {
+		payments, errs := offerer.SubscribeOfferPayments(ctx)
+		err := e.routeOfferPayments(ctx, payments, errs)
+		if ctx.Err() != nil {
+			return
+		}
+		e.log.Printf("offer payments stream %+v", err)
+
+		select {
+		case <-time.After(offerStreamBackoff):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *Engine) routeOfferPayments(
+	ctx context.Context,
+	payments chan *lightning.OfferPayment,
+	errs chan error,
+) error {
+	for {
+		select {
+		case payment, ok := <-payments:
+			if !ok {
+				return errors.New("stream closed")
+			}
+
+			for _, jobID := range jobIDPattern.FindAllString(payment.PayerNote, -1) {
+				key := payment.OfferID + ":" + jobID
+
+				e.offersMu.Lock()
+				wait, ok := e.offerWaits[key]
+				if ok && payment.AmountSats >= wait.amountSats {
+					delete(e.offerWaits, key)
+				}
+				e.offersMu.Unlock()
+
+				if !ok {
+					continue
+				}
+				if payment.AmountSats < wait.amountSats {
+					e.log.Printf("offer payment %s for job %s underpaid", payment.Hash, jobID)
+					continue
+				}
+
+				wait.paid()
+				break
+			}
+		case err, ok := <-errs:
+			if !ok {
+				return errors.New("stream closed")
+			}
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
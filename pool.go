@@ -0,0 +1,524 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	relayConnectTimeout = 10 * time.Second
+	minRelayBackoff     = time.Second
+	maxRelayBackoff     = 5 * time.Minute
+
+	// resubscribeOverlap is subtracted from the time a relay subscription went down when it's opened again, so
+	// events created just before the disconnection, or by clocks slightly behind ours, are not missed.
+	resubscribeOverlap = 2 * time.Minute
+)
+
+var (
+	ErrUnknownRelay   = errors.New("relay is not in the pool")
+	ErrPoolNotRunning = errors.New("relay pool is not running")
+
+	errRelayConnectionClosed = errors.New("relay connection closed")
+)
+
+type RelayState int
+
+const (
+	RelayStateConnecting   RelayState = 0
+	RelayStateConnected    RelayState = 1
+	RelayStateDisconnected RelayState = 2
+)
+
+var relayStateToString = map[RelayState]string{
+	RelayStateConnecting:   "connecting",
+	RelayStateConnected:    "connected",
+	RelayStateDisconnected: "disconnected",
+}
+
+func (s RelayState) String() string {
+	return relayStateToString[s]
+}
+
+// RelayStatus is the connection state of a relay of the pool.
+type RelayStatus struct {
+	URL   string
+	State RelayState
+
+	// ConnectedAt is when the current connection was established, zero when not connected.
+	ConnectedAt time.Time
+
+	// Reconnects counts the connections established after the first one.
+	Reconnects int
+
+	// LastError is the error that ended the last connection or connection attempt.
+	LastError error
+
+	// NextRetryAt is when the next connection attempt happens, while disconnected.
+	NextRetryAt time.Time
+}
+
+// relayPool keeps a connection open to every relay, reconnecting with exponential backoff when it drops. Its
+// subscriptions are opened on every relay and opened again after each reconnection, since the time they went down.
+type relayPool struct {
+	log *log.Logger
+
+	mu        sync.Mutex
+	ctx       context.Context
+	conns     map[string]*relayConn
+	urls      []string
+	subs      map[*poolSub]struct{}
+	signerFor func(pubkey string) Signer
+}
+
+type relayConn struct {
+	url    string
+	cancel context.CancelFunc
+
+	// ready is closed once the first connection attempt finished, successfully or not.
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu     sync.Mutex
+	relay  *goNostr.Relay
+	status RelayStatus
+}
+
+// poolSub is a subscription opened on every relay of the pool.
+type poolSub struct {
+	ctx     context.Context
+	filters goNostr.Filters
+	onEvent func(relayURL string, event *goNostr.Event)
+	wg      sync.WaitGroup
+
+	// since returns when the subscription starts on a relay it was never opened on, nil to use the filters as is.
+	since func(relayURL string) time.Time
+
+	// onLive, when set, is called once a relay sent all its stored events, with true, and when the subscription
+	// goes down on it, with false.
+	onLive func(relayURL string, live bool)
+
+	mu sync.Mutex
+	// downSince is when the subscription went down on each relay, used as Since when it's opened again. It only
+	// moves once the relay sent all the stored events since then, so a relay that drops again before that is asked
+	// for the whole gap once more.
+	downSince map[string]time.Time
+	// recovered is set for the relays that sent all their stored events since the subscription was last opened.
+	recovered map[string]bool
+}
+
+func newRelayPool(logger *log.Logger) *relayPool {
+	return &relayPool{
+		log:   logger,
+		conns: make(map[string]*relayConn),
+		subs:  make(map[*poolSub]struct{}),
+	}
+}
+
+// start makes the pool keep its relays connected until ctx is done.
+func (p *relayPool) start(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ctx = ctx
+}
+
+// add starts connecting to the relay. It returns the normalized URL of the relay and a channel closed once the
+// first connection attempt finished.
+func (p *relayPool) add(url string) (string, <-chan struct{}, error) {
+	url = goNostr.NormalizeURL(url)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ctx == nil {
+		return url, nil, ErrPoolNotRunning
+	}
+
+	if conn, ok := p.conns[url]; ok {
+		return url, conn.ready, nil
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	conn := &relayConn{
+		url:    url,
+		cancel: cancel,
+		ready:  make(chan struct{}),
+		status: RelayStatus{
+			URL:   url,
+			State: RelayStateConnecting,
+		},
+	}
+	p.conns[url] = conn
+	p.urls = append(p.urls, url)
+
+	go p.keepConnected(ctx, conn)
+
+	return url, conn.ready, nil
+}
+
+// remove closes the connection to the relay, ending its subscriptions, and stops reconnecting to it.
+func (p *relayPool) remove(url string) error {
+	url = goNostr.NormalizeURL(url)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conn, ok := p.conns[url]
+	if !ok {
+		return ErrUnknownRelay
+	}
+
+	conn.cancel()
+	delete(p.conns, url)
+	for i := range p.urls {
+		if p.urls[i] == url {
+			p.urls = append(p.urls[:i], p.urls[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// has reports whether the relay is in the pool, connected or not.
+func (p *relayPool) has(url string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.conns[goNostr.NormalizeURL(url)]
+
+	return ok
+}
+
+// relay returns the connection to the relay, if it's in the pool and connected.
+func (p *relayPool) relay(url string) (*goNostr.Relay, bool) {
+	p.mu.Lock()
+	conn, ok := p.conns[goNostr.NormalizeURL(url)]
+	p.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.relay == nil || !conn.relay.IsConnected() {
+		return nil, false
+	}
+
+	return conn.relay, true
+}
+
+// statuses returns the state of every relay, in the order they were added.
+func (p *relayPool) statuses() []RelayStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]RelayStatus, 0, len(p.urls))
+	for _, url := range p.urls {
+		conn := p.conns[url]
+		conn.mu.Lock()
+		statuses = append(statuses, conn.status)
+		conn.mu.Unlock()
+	}
+
+	return statuses
+}
+
+// connected returns the relays that are currently connected.
+func (p *relayPool) connected() []*goNostr.Relay {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	relays := make([]*goNostr.Relay, 0, len(p.urls))
+	for _, url := range p.urls {
+		conn := p.conns[url]
+		conn.mu.Lock()
+		if conn.relay != nil && conn.relay.IsConnected() {
+			relays = append(relays, conn.relay)
+		}
+		conn.mu.Unlock()
+	}
+
+	return relays
+}
+
+// subscribe opens the subscription on every relay, now and after every reconnection, until ctx is done. onEvent
+// is called concurrently from every relay. The returned channel is closed once ctx is done and onEvent won't be
+// called anymore.
+func (p *relayPool) subscribe(
+	ctx context.Context,
+	filters goNostr.Filters,
+	onEvent func(relayURL string, event *goNostr.Event),
+) <-chan struct{} {
+	return p.subscribeSince(ctx, filters, nil, onEvent, nil)
+}
+
+// subscribeSince is like subscribe, but the subscription starts on each relay at the time returned by since.
+// onLive, if not nil, tells when each relay is done sending its stored events, see poolSub.
+func (p *relayPool) subscribeSince(
+	ctx context.Context,
+	filters goNostr.Filters,
+	since func(relayURL string) time.Time,
+	onEvent func(relayURL string, event *goNostr.Event),
+	onLive func(relayURL string, live bool),
+) <-chan struct{} {
+	sub := &poolSub{
+		ctx:       ctx,
+		filters:   filters,
+		onEvent:   onEvent,
+		since:     since,
+		onLive:    onLive,
+		downSince: make(map[string]time.Time),
+		recovered: make(map[string]bool),
+	}
+
+	p.mu.Lock()
+	p.subs[sub] = struct{}{}
+	for _, url := range p.urls {
+		conn := p.conns[url]
+		conn.mu.Lock()
+		if conn.relay != nil {
+			p.startSub(sub, conn.relay)
+		}
+		conn.mu.Unlock()
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+
+		p.mu.Lock()
+		delete(p.subs, sub)
+		p.mu.Unlock()
+
+		sub.wg.Wait()
+		close(done)
+	}()
+
+	return done
+}
+
+func (p *relayPool) keepConnected(ctx context.Context, conn *relayConn) {
+	defer conn.markReady()
+
+	backoff := minRelayBackoff
+	everConnected := false
+
+	for {
+		connectCtx, cancel := context.WithTimeout(ctx, relayConnectTimeout)
+		relay, err := goNostr.RelayConnect(connectCtx, conn.url)
+		cancel()
+		if ctx.Err() != nil {
+			if err == nil {
+				relay.Close()
+			}
+			return
+		}
+
+		if err == nil {
+			backoff = minRelayBackoff
+			p.markConnected(conn, relay, everConnected)
+			everConnected = true
+			conn.markReady()
+
+			select {
+			case <-relay.Context().Done():
+				err = relay.ConnectionError
+				if err == nil {
+					err = errRelayConnectionClosed
+				}
+				p.log.Printf("relay %s disconnected %+v", conn.url, err)
+			case <-ctx.Done():
+				relay.Close()
+				return
+			}
+		} else {
+			p.log.Printf("connect to relay %s %+v", conn.url, err)
+		}
+
+		conn.mu.Lock()
+		conn.relay = nil
+		conn.status.State = RelayStateDisconnected
+		conn.status.ConnectedAt = time.Time{}
+		conn.status.LastError = err
+		conn.status.NextRetryAt = time.Now().Add(backoff)
+		conn.mu.Unlock()
+		conn.markReady()
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, maxRelayBackoff)
+
+		conn.mu.Lock()
+		conn.status.State = RelayStateConnecting
+		conn.status.NextRetryAt = time.Time{}
+		conn.mu.Unlock()
+	}
+}
+
+// markConnected marks the relay as connected and opens every subscription of the pool on it.
+func (p *relayPool) markConnected(conn *relayConn, relay *goNostr.Relay, reconnect bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	conn.relay = relay
+	conn.status.State = RelayStateConnected
+	conn.status.ConnectedAt = time.Now()
+	if reconnect {
+		conn.status.Reconnects++
+	}
+
+	for sub := range p.subs {
+		p.startSub(sub, relay)
+	}
+}
+
+func (conn *relayConn) markReady() {
+	conn.readyOnce.Do(func() {
+		close(conn.ready)
+	})
+}
+
+// startSub must be called with p.mu held, so that no subscription is started after its context is done and its
+// done channel closed.
+func (p *relayPool) startSub(sub *poolSub, relay *goNostr.Relay) {
+	if sub.ctx.Err() != nil {
+		return
+	}
+
+	sub.wg.Add(1)
+	go func() {
+		defer sub.wg.Done()
+		p.runSub(sub, relay)
+	}()
+}
+
+// runSub keeps the subscription open on the relay until the connection drops or the subscription context is done.
+// Subscriptions closed by the relay are opened again with backoff, or right after authenticating if the relay
+// requires it.
+func (p *relayPool) runSub(sub *poolSub, relay *goNostr.Relay) {
+	backoff := minRelayBackoff
+	authenticated := false
+
+	for {
+		filters := sub.filtersFor(relay.URL)
+		relaySub, err := relay.Subscribe(sub.ctx, filters)
+		if err != nil {
+			p.log.Printf("subscribe to relay %s %+v", relay.URL, err)
+			sub.wentDown(relay.URL)
+			return
+		}
+
+		reason, closed := p.readSub(sub, relay.URL, relaySub)
+		sub.wentDown(relay.URL)
+		if !closed {
+			return
+		}
+
+		if isAuthRequired(reason) && !authenticated {
+			authenticated = true
+			err := p.authenticate(sub.ctx, relay, "")
+			if err == nil {
+				continue
+			}
+			p.log.Printf("authenticate to relay %s %+v", relay.URL, err)
+		}
+
+		p.log.Printf("relay %s closed subscription: %s", relay.URL, reason)
+		select {
+		case <-time.After(backoff):
+		case <-sub.ctx.Done():
+			return
+		case <-relay.Context().Done():
+			return
+		}
+		backoff = min(backoff*2, maxRelayBackoff)
+	}
+}
+
+// readSub delivers the events of the relay subscription until it ends. It reports whether it ended because the
+// relay closed it, along with the reason.
+func (p *relayPool) readSub(sub *poolSub, url string, relaySub *goNostr.Subscription) (string, bool) {
+	eose := relaySub.EndOfStoredEvents
+	for {
+		select {
+		case event, ok := <-relaySub.Events:
+			if !ok || event == nil {
+				return "", false
+			}
+			sub.onEvent(url, event)
+		case <-eose:
+			// the channel is closed, stop selecting it.
+			eose = nil
+			sub.mu.Lock()
+			sub.recovered[url] = true
+			sub.mu.Unlock()
+			if sub.onLive != nil {
+				sub.onLive(url, true)
+			}
+		case reason, ok := <-relaySub.ClosedReason:
+			relaySub.Unsub()
+			return reason, ok
+		case <-sub.ctx.Done():
+			return "", false
+		}
+	}
+}
+
+// filtersFor returns the filters of the subscription, with Since moved to when the subscription went down on the
+// relay, if it did, or to when it starts on the relay.
+func (sub *poolSub) filtersFor(url string) goNostr.Filters {
+	sub.mu.Lock()
+	downSince, ok := sub.downSince[url]
+	sub.mu.Unlock()
+	if ok {
+		return sub.filtersSince(goNostr.Timestamp(downSince.Add(-resubscribeOverlap).Unix()))
+	}
+	if sub.since != nil {
+		return sub.filtersSince(goNostr.Timestamp(sub.since(url).Unix()))
+	}
+
+	return sub.filters
+}
+
+// filtersSince returns the filters of the subscription, with Since moved forward to since.
+func (sub *poolSub) filtersSince(since goNostr.Timestamp) goNostr.Filters {
+	filters := make(goNostr.Filters, len(sub.filters))
+	for i := range sub.filters {
+		filters[i] = sub.filters[i]
+		if filters[i].Since == nil || *filters[i].Since < since {
+			filters[i].Since = &since
+		}
+	}
+
+	return filters
+}
+
+// wentDown records when the subscription went down on the relay, unless the relay didn't send all the stored events
+// it was asked for yet: the next subscription then starts where this one did.
+func (sub *poolSub) wentDown(url string) {
+	sub.mu.Lock()
+	if sub.recovered[url] {
+		sub.downSince[url] = time.Now()
+	}
+	sub.recovered[url] = false
+	sub.mu.Unlock()
+
+	if sub.onLive != nil {
+		sub.onLive(url, false)
+	}
+}
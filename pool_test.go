@@ -0,0 +1,44 @@
+package godvm
+
+import (
+	"testing"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+func TestPoolSubResumesFromTheEarliestGap(t *testing.T) {
+	start := goNostr.Timestamp(time.Now().Add(-time.Hour).Unix())
+	sub := &poolSub{
+		filters:   goNostr.Filters{{Kinds: []int{5050}, Since: &start}},
+		downSince: make(map[string]time.Time),
+		recovered: make(map[string]bool),
+	}
+	since := func() goNostr.Timestamp {
+		return *sub.filtersFor("wss://relay")[0].Since
+	}
+
+	// dropped before sending its stored events: asked for them all again.
+	sub.wentDown("wss://relay")
+	if got := since(); got != start {
+		t.Fatalf("got since %d, want %d", got, start)
+	}
+
+	sub.recovered["wss://relay"] = true
+	sub.wentDown("wss://relay")
+	firstGap := since()
+	if firstGap <= start {
+		t.Fatalf("got since %d, want after %d", firstGap, start)
+	}
+
+	// dropped again before recovering the first gap.
+	time.Sleep(time.Second)
+	sub.wentDown("wss://relay")
+	if got := since(); got != firstGap {
+		t.Fatalf("got since %d, want the first gap %d", got, firstGap)
+	}
+
+	if got := *sub.filtersFor("wss://other")[0].Since; got != start {
+		t.Fatalf("got since %d on another relay, want %d", got, start)
+	}
+}
@@ -0,0 +1,176 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/sebdeveloper6952/godvm/publishqueue"
+)
+
+const (
+	defaultPublishMaxAttempts = 8
+	publishRetryInterval      = 5 * time.Second
+	minPublishRetryBackoff    = 30 * time.Second
+	maxPublishRetryBackoff    = 30 * time.Minute
+)
+
+var (
+	ErrPublishRetriesDisabled = errors.New("publish retries are not enabled")
+	ErrNotADeadLetter         = errors.New("event is not a dead letter")
+)
+
+type PublishRetryConfig struct {
+	Store publishqueue.Store
+
+	// MaxAttempts is how many times an event is published again before it becomes a dead letter. Defaults to 8.
+	MaxAttempts int
+}
+
+// SetPublishQuorum sets how many relays must accept an event for it to count as published. It's capped at the
+// number of relays the event is published to. Defaults to 1.
+func (e *Engine) SetPublishQuorum(n int) {
+	e.nostrSvc.SetPublishQuorum(n)
+}
+
+// EnablePublishRetries makes the engine publish again, with exponential backoff, the job results that were not
+// accepted by enough relays. Results that fail MaxAttempts times are kept in the store as dead letters, see
+// DeadLetters.
+func (e *Engine) EnablePublishRetries(cfg PublishRetryConfig) {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultPublishMaxAttempts
+	}
+	e.publishRetries = &cfg
+}
+
+// DeadLetters returns the events that failed to publish too many times, oldest first.
+func (e *Engine) DeadLetters(ctx context.Context) ([]*publishqueue.Entry, error) {
+	if e.publishRetries == nil {
+		return nil, ErrPublishRetriesDisabled
+	}
+
+	entries, err := e.publishRetries.Store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dead := make([]*publishqueue.Entry, 0)
+	for _, entry := range entries {
+		if entry.Dead {
+			dead = append(dead, entry)
+		}
+	}
+
+	return dead, nil
+}
+
+// RetryDeadLetter queues the dead letter of the event to be published again, with its attempts reset.
+func (e *Engine) RetryDeadLetter(ctx context.Context, eventID string) error {
+	if e.publishRetries == nil {
+		return ErrPublishRetriesDisabled
+	}
+
+	entries, err := e.publishRetries.Store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Event.ID != eventID || !entry.Dead {
+			continue
+		}
+		entry.Dead = false
+		entry.Attempts = 0
+		entry.NextAttemptAt = time.Now()
+
+		return e.publishRetries.Store.Save(ctx, entry)
+	}
+
+	return ErrNotADeadLetter
+}
+
+// enqueuePublish queues the event to be published again later.
+func (e *Engine) enqueuePublish(ctx context.Context, ev *goNostr.Event, relays []string, err error) error {
+	e.log.Printf("queue event %s to publish again %+v", ev.ID, err)
+
+	now := time.Now()
+
+	return e.publishRetries.Store.Save(ctx, &publishqueue.Entry{
+		Event:         *ev,
+		Relays:        relays,
+		LastError:     err.Error(),
+		NextAttemptAt: now.Add(minPublishRetryBackoff),
+		CreatedAt:     now,
+	})
+}
+
+// retryPublishes publishes again the queued events that are due, until ctx is done.
+func (e *Engine) retryPublishes(ctx context.Context) {
+	if e.publishRetries == nil {
+		return
+	}
+
+	ticker := time.NewTicker(publishRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.retryDuePublishes(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *Engine) retryDuePublishes(ctx context.Context) {
+	store := e.publishRetries.Store
+
+	entries, err := store.List(ctx)
+	if err != nil {
+		e.log.Printf("list queued events %+v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Dead || entry.NextAttemptAt.After(now) {
+			continue
+		}
+
+		_, err := e.nostrSvc.PublishEvent(ctx, entry.Event, entry.Relays...)
+		if err == nil {
+			e.log.Printf("published queued event %s", entry.Event.ID)
+			if err := store.Delete(ctx, entry.Event.ID); err != nil {
+				e.log.Printf("delete queued event %s %+v", entry.Event.ID, err)
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		entry.Attempts++
+		entry.LastError = err.Error()
+		if entry.Attempts >= e.publishRetries.MaxAttempts {
+			entry.Dead = true
+			e.log.Printf("queued event %s failed %d times, moved to dead letters %+v", entry.Event.ID, entry.Attempts, err)
+		} else {
+			entry.NextAttemptAt = time.Now().Add(publishRetryBackoff(entry.Attempts))
+		}
+
+		if err := store.Save(ctx, entry); err != nil {
+			e.log.Printf("save queued event %s %+v", entry.Event.ID, err)
+		}
+	}
+}
+
+func publishRetryBackoff(attempts int) time.Duration {
+	backoff := minPublishRetryBackoff
+	for i := 0; i < attempts && backoff < maxPublishRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxPublishRetryBackoff)
+}
@@ -0,0 +1,97 @@
+package publishqueue
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/sebdeveloper6952/godvm/internal/filestore"
+)
+
+type memory struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*Entry
+}
+
+// NewMemory returns a Store that only lives in memory.
+func NewMemory() Store {
+	return &memory{
+		entries: make(map[string]*Entry),
+	}
+}
+
+// NewFile returns a Store kept in memory and saved as JSON to path after every change. Existing entries in path are
+// loaded first.
+func NewFile(path string) (Store, error) {
+	m := &memory{
+		path:    path,
+		entries: make(map[string]*Entry),
+	}
+
+	entries := make([]*Entry, 0)
+	if err := filestore.Load(path, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		m.entries[entry.Event.ID] = entry
+	}
+
+	return m, nil
+}
+
+func (m *memory) Save(ctx context.Context, entry *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[entry.Event.ID] = copyEntry(entry)
+
+	return m.save()
+}
+
+func (m *memory) Delete(ctx context.Context, eventID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[eventID]; !ok {
+		return nil
+	}
+	delete(m.entries, eventID)
+
+	return m.save()
+}
+
+func (m *memory) List(ctx context.Context) ([]*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.list(), nil
+}
+
+func (m *memory) list() []*Entry {
+	entries := make([]*Entry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, copyEntry(entry))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	return entries
+}
+
+func (m *memory) save() error {
+	if m.path == "" {
+		return nil
+	}
+
+	return filestore.Save(m.path, m.list())
+}
+
+func copyEntry(entry *Entry) *Entry {
+	copied := *entry
+	copied.Relays = append([]string(nil), entry.Relays...)
+
+	return &copied
+}
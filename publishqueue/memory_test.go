@@ -0,0 +1,74 @@
+package publishqueue
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "queue.json")
+	now := time.Now().Truncate(time.Second)
+
+	store, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []*Entry{
+		{Event: goNostr.Event{ID: "b"}, CreatedAt: now.Add(time.Minute), Relays: []string{"wss://b"}},
+		{Event: goNostr.Event{ID: "a"}, CreatedAt: now},
+		{Event: goNostr.Event{ID: "c"}, CreatedAt: now.Add(2 * time.Minute)},
+	}
+	for _, entry := range entries {
+		if err := store.Save(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// saved entries are copies.
+	entries[0].Relays[0] = "wss://changed"
+
+	dead := &Entry{Event: goNostr.Event{ID: "c"}, CreatedAt: now.Add(2 * time.Minute), Dead: true}
+	if err := store.Save(ctx, dead); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "unknown"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []Store{store, reopened} {
+		list, err := s.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].Event.ID != "b" || list[1].Event.ID != "c" {
+			t.Fatalf("unexpected entries %+v", list)
+		}
+		if list[0].Relays[0] != "wss://b" {
+			t.Errorf("got relays %v, want [wss://b]", list[0].Relays)
+		}
+		if !list[1].Dead {
+			t.Error("entry not replaced")
+		}
+	}
+
+	// listed entries are copies too.
+	list, _ := store.List(ctx)
+	list[0].Relays[0] = "wss://changed"
+	if list, _ = store.List(ctx); list[0].Relays[0] != "wss://b" {
+		t.Errorf("got relays %v after changing a listed entry, want [wss://b]", list[0].Relays)
+	}
+}
@@ -0,0 +1,37 @@
+// Package publishqueue keeps the events that were not accepted by enough relays, so they can be published again
+// later. Events that keep failing are kept as dead letters for operators to inspect.
+package publishqueue
+
+import (
+	"context"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+// Entry is an event waiting to be published again.
+type Entry struct {
+	Event goNostr.Event `json:"event"`
+
+	// Relays are the relays the event is published to besides the engine relays.
+	Relays []string `json:"relays,omitempty"`
+
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// Dead is set once the event failed too many times. Dead entries are not published again.
+	Dead bool `json:"dead,omitempty"`
+}
+
+type Store interface {
+	// Save adds the entry, replacing any other for the same event.
+	Save(ctx context.Context, entry *Entry) error
+
+	// Delete removes the entry of the event. Deleting an unknown event is not an error.
+	Delete(ctx context.Context, eventID string) error
+
+	// List returns copies of every entry, dead or not, oldest first.
+	List(ctx context.Context) ([]*Entry, error)
+}
@@ -0,0 +1,168 @@
+package godvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+	"github.com/sebdeveloper6952/godvm/ledger"
+	"github.com/sebdeveloper6952/godvm/lightning"
+)
+
+const (
+	// ParamRefund is the job request param a customer can use to choose the lightning address refunds are sent to,
+	// instead of the lud16 of their profile.
+	ParamRefund = "refund"
+
+	refundTimeout = time.Minute
+)
+
+var (
+	ErrNoRefundAddress = errors.New("customer has no lightning address to refund to")
+)
+
+type RefundConfig struct {
+	// Percent of the paid amount that is refunded when the DVM doesn't set JobUpdate.RefundSats. Defaults to 100.
+	Percent int
+
+	// Comment is sent along with the refund payment, when the receiving wallet allows comments.
+	Comment string
+
+	// HTTPClient is used to resolve lightning addresses. Defaults to a client with a 30 seconds timeout.
+	HTTPClient *http.Client
+}
+
+// EnableRefunds makes the engine refund customers whose paid job ends with StatusError. The refund is paid to the
+// lightning address in the request `refund` param, or else to the lud16 of the customer kind 0 profile. The
+// lightning service must be able to pay invoices, see lightning.Payer. Jobs paid with credits are refunded to the
+// customer balance instead.
+func (e *Engine) EnableRefunds(cfg RefundConfig) {
+	if cfg.Percent <= 0 || cfg.Percent > 100 {
+		cfg.Percent = 100
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	e.refunds = &cfg
+}
+
+// refund pays back the customer of a failed job and publishes the outcome as a feedback event.
+func (e *Engine) refund(ctx context.Context, run *jobRun, update *JobUpdate) {
+	paidSats := run.paidSats.Load()
+	amountSats := paidSats * int64(e.refunds.Percent) / 100
+	if update.RefundSats > 0 {
+		amountSats = min(int64(update.RefundSats), paidSats)
+	}
+	if amountSats <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, refundTimeout)
+	defer cancel()
+
+	toCredits := e.credits != nil && run.paidCreditsSats.Load() > 0
+
+	var err error
+	if toCredits {
+		_, err = e.credits.Store.Credit(
+			ctx,
+			run.input.CustomerPubkey,
+			amountSats,
+			"refund:"+run.input.JobRequestId+":"+run.dvm.PublicKeyHex(),
+		)
+	} else {
+		err = e.payRefund(ctx, run, amountSats)
+	}
+
+	e.recordJob(ctx, run.dvm, run.input, func(entry *ledger.Entry) {
+		if err != nil {
+			entry.RefundError = err.Error()
+			return
+		}
+		entry.AmountRefundedSats += amountSats
+		entry.RefundedAt = time.Now()
+		entry.RefundError = ""
+	})
+
+	feedback := &JobUpdate{
+		Status:     StatusError,
+		FailureMsg: fmt.Sprintf("refunded %d sats", amountSats),
+		ExtraTags: [][]string{
+			{"refund", fmt.Sprintf("%d", amountSats*1000), "success"},
+		},
+	}
+	if toCredits {
+		feedback.FailureMsg = fmt.Sprintf("refunded %d sats to your balance", amountSats)
+	}
+	if err != nil {
+		e.log.Printf("refund job %s %+v", run.input.JobRequestId, err)
+		feedback.FailureMsg = fmt.Sprintf("refund of %d sats failed: %s", amountSats, err)
+		feedback.ExtraTags[0][2] = "failed"
+	}
+
+	if err := e.sendFeedbackEvent(ctx, run.dvm, run.input, feedback); err != nil {
+		e.log.Printf("publish refund feedback %+v", err)
+	}
+}
+
+func (e *Engine) payRefund(ctx context.Context, run *jobRun, amountSats int64) error {
+	lnSvc, err := e.lnServiceFor(run.dvm)
+	if err != nil {
+		return err
+	}
+
+	address, err := e.refundAddress(ctx, run.input)
+	if err != nil {
+		return err
+	}
+
+	_, err = lightning.PayLightningAddress(
+		ctx,
+		lnSvc,
+		e.refunds.HTTPClient,
+		address,
+		amountSats,
+		e.refunds.Comment,
+	)
+
+	return err
+}
+
+func (e *Engine) refundAddress(ctx context.Context, input *Nip90Input) (string, error) {
+	if address, ok := input.Param(ParamRefund); ok && address != "" {
+		return address, nil
+	}
+
+	profileEvent, err := e.nostrSvc.FetchLatestEvent(
+		ctx,
+		goNostr.Filter{
+			Kinds:   []int{KindProfileMetadata},
+			Authors: []string{input.CustomerPubkey},
+			Limit:   1,
+		},
+		input.Relays...,
+	)
+	if errors.Is(err, ErrEventNotFound) {
+		return "", ErrNoRefundAddress
+	}
+	if err != nil {
+		return "", err
+	}
+	// the profile comes from any relay of the customer, which could otherwise point the refund to its own address.
+	if ok, _ := profileEvent.CheckSignature(); !ok || profileEvent.PubKey != input.CustomerPubkey ||
+		profileEvent.GetID() != profileEvent.ID {
+		return "", ErrNoRefundAddress
+	}
+
+	profile := &ProfileMetadata{}
+	if err := json.Unmarshal([]byte(profileEvent.Content), profile); err != nil || profile.Lud16 == "" {
+		return "", ErrNoRefundAddress
+	}
+
+	return profile.Lud16, nil
+}
@@ -0,0 +1,169 @@
+package godvm
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/sebdeveloper6952/godvm/ledger"
+)
+
+// UnlockMode controls what a job result sent with StatusSuccessWithPayment reveals before its invoice is paid.
+type UnlockMode int
+
+const (
+	// UnlockModeNone publishes the result in the clear along with the invoice.
+	UnlockModeNone UnlockMode = 0
+
+	// UnlockModeEncrypt publishes the result encrypted with a per-job key, tagged ["unlock", "aes-256-gcm"]. Once
+	// the invoice is paid, the key is published to the customer in a success feedback event, tagged
+	// ["unlock", "aes-256-gcm", "<hex key>"]. See DecryptJobResult.
+	UnlockModeEncrypt UnlockMode = 1
+
+	// UnlockModeWithhold publishes the job result without content, tagged ["unlock", "withheld"]. Once the
+	// invoice is paid, the result is published again with its content.
+	UnlockModeWithhold UnlockMode = 2
+
+	UnlockSchemeAES256GCM = "aes-256-gcm"
+	UnlockSchemeWithheld  = "withheld"
+)
+
+var (
+	ErrJobNotPaid        = errors.New("job result was not paid")
+	ErrInvalidCiphertext = errors.New("invalid job result ciphertext")
+)
+
+func (e *Engine) unlockModeFor(dvm Dvmer) UnlockMode {
+	if cfg, ok := e.dvmConfigs[dvm.PublicKeyHex()]; ok {
+		return cfg.unlockMode
+	}
+
+	return UnlockModeNone
+}
+
+// sendLockedJobResult publishes the locked result along with its invoice and, once paid, unlocks it for the
+// customer.
+func (e *Engine) sendLockedJobResult(
+	ctx context.Context,
+	run *jobRun,
+	update *JobUpdate,
+	paid <-chan bool,
+) error {
+	mode := e.unlockModeFor(run.dvm)
+
+	locked := *update
+	locked.ExtraTags = append([][]string{}, update.ExtraTags...)
+
+	var key []byte
+	switch mode {
+	case UnlockModeEncrypt:
+		ciphertext, resultKey, err := encryptJobResult(update.Result)
+		if err != nil {
+			return err
+		}
+		key = resultKey
+		locked.Result = ciphertext
+		locked.ExtraTags = append(locked.ExtraTags, []string{"unlock", UnlockSchemeAES256GCM})
+	default:
+		locked.Result = ""
+		locked.ExtraTags = append(locked.ExtraTags, []string{"unlock", UnlockSchemeWithheld})
+	}
+
+	if err := e.sendJobResultEvent(ctx, run.dvm, run.input, &locked); err != nil {
+		return err
+	}
+
+	select {
+	case ok := <-paid:
+		if !ok {
+			return ErrJobNotPaid
+		}
+	case <-ctx.Done():
+		return nil
+	}
+
+	e.recordJob(ctx, run.dvm, run.input, func(entry *ledger.Entry) {
+		entry.Status = "unlocked"
+	})
+
+	if mode == UnlockModeEncrypt {
+		return e.sendFeedbackEvent(ctx, run.dvm, run.input, &JobUpdate{
+			Status: StatusSuccess,
+			ExtraTags: [][]string{
+				{"unlock", UnlockSchemeAES256GCM, hex.EncodeToString(key)},
+			},
+		})
+	}
+
+	return e.sendJobResultEvent(ctx, run.dvm, run.input, &JobUpdate{
+		Status:    StatusSuccess,
+		Result:    update.Result,
+		ExtraTags: update.ExtraTags,
+	})
+}
+
+// encryptJobResult encrypts the result with a new random key. The ciphertext is the base64 of the GCM nonce
+// followed by the sealed result.
+func encryptJobResult(result string) (string, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(result), nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), key, nil
+}
+
+// DecryptJobResult decrypts the content of a job result published with UnlockModeEncrypt, using the hex key
+// released in the unlock feedback event.
+func DecryptJobResult(ciphertext string, keyHex string) (string, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", ErrInvalidCiphertext
+	}
+
+	result, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	return string(result), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
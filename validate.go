@@ -0,0 +1,195 @@
+package godvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	defaultMaxCreatedAtSkew = 10 * time.Minute
+	defaultMaxInputs        = 20
+	defaultMaxInputLength   = 64 * 1024
+)
+
+var (
+	ErrInvalidJobRequest = errors.New("invalid job request")
+)
+
+// RequestLimits bounds the job requests the engine serves. Requests outside the limits get an error feedback.
+type RequestLimits struct {
+	// MaxCreatedAtSkew is how far in the future the created_at of a job request may be. Old requests are not
+	// rejected, see EnableCatchUp. Defaults to 10 minutes.
+	MaxCreatedAtSkew time.Duration
+
+	// MaxInputs is the maximum number of `i` tags of a job request. Defaults to 20.
+	MaxInputs int
+
+	// MaxInputLength is the maximum length in bytes of the value of an input. Defaults to 64 KiB.
+	MaxInputLength int
+}
+
+func (l RequestLimits) withDefaults() RequestLimits {
+	if l.MaxCreatedAtSkew <= 0 {
+		l.MaxCreatedAtSkew = defaultMaxCreatedAtSkew
+	}
+	if l.MaxInputs <= 0 {
+		l.MaxInputs = defaultMaxInputs
+	}
+	if l.MaxInputLength <= 0 {
+		l.MaxInputLength = defaultMaxInputLength
+	}
+
+	return l
+}
+
+// SetRequestLimits sets the limits of the job requests the engine serves. Zero fields keep their default.
+func (e *Engine) SetRequestLimits(l RequestLimits) {
+	e.requestLimits = l.withDefaults()
+}
+
+// authentic reports whether the ID of the event is the hash of its content and its signature is valid.
+func authentic(event *goNostr.Event) bool {
+	if event.GetID() != event.ID {
+		return false
+	}
+	ok, _ := event.CheckSignature()
+
+	return ok
+}
+
+// ValidateJobRequest returns an error wrapping ErrInvalidJobRequest that tells what is wrong with the job request:
+// a kind outside 5000-5999, a created_at too far in the future, malformed tags or inputs over the limits. It
+// doesn't check the signature.
+func ValidateJobRequest(event *goNostr.Event, limits RequestLimits) error {
+	limits = limits.withDefaults()
+
+	if event.Kind < 5000 || event.Kind > 5999 {
+		return fmt.Errorf("%w: kind %d is not a job request kind", ErrInvalidJobRequest, event.Kind)
+	}
+	if skew := time.Until(event.CreatedAt.Time()); skew > limits.MaxCreatedAtSkew {
+		return fmt.Errorf("%w: created_at is %s in the future", ErrInvalidJobRequest, skew.Round(time.Second))
+	}
+
+	inputs := 0
+	for _, tag := range event.Tags {
+		if len(tag) == 0 {
+			return fmt.Errorf("%w: empty tag", ErrInvalidJobRequest)
+		}
+
+		switch tag[0] {
+		case "i":
+			inputs++
+			if err := validateInputTag(tag, limits); err != nil {
+				return fmt.Errorf("%w: input %d: %w", ErrInvalidJobRequest, inputs, err)
+			}
+		case "bid":
+			if len(tag) < 2 {
+				return fmt.Errorf("%w: bid tag without amount", ErrInvalidJobRequest)
+			}
+			if bid, err := strconv.Atoi(tag[1]); err != nil || bid < 0 {
+				return fmt.Errorf("%w: bid %q is not an amount of millisats", ErrInvalidJobRequest, tag[1])
+			}
+		case "param":
+			if len(tag) != 3 {
+				return fmt.Errorf("%w: param tag must be [\"param\", name, value]", ErrInvalidJobRequest)
+			}
+		case "output":
+			if len(tag) < 2 || tag[1] == "" {
+				return fmt.Errorf("%w: output tag without mime type", ErrInvalidJobRequest)
+			}
+		case "relays":
+			for _, relay := range tag[1:] {
+				if !isRelayURL(relay) {
+					return fmt.Errorf("%w: relay %q is not a websocket URL", ErrInvalidJobRequest, relay)
+				}
+			}
+		case "p":
+			if len(tag) < 2 || !isHexID(tag[1]) {
+				return fmt.Errorf("%w: p tag is not a hex public key", ErrInvalidJobRequest)
+			}
+		}
+	}
+	if inputs > limits.MaxInputs {
+		return fmt.Errorf("%w: %d inputs over the limit of %d", ErrInvalidJobRequest, inputs, limits.MaxInputs)
+	}
+
+	return nil
+}
+
+func validateInputTag(tag goNostr.Tag, limits RequestLimits) error {
+	if len(tag) < 2 || len(tag) > 5 {
+		return errors.New("tag must be [\"i\", value, type, relay, marker]")
+	}
+	if len(tag[1]) > limits.MaxInputLength {
+		return fmt.Errorf("value of %d bytes over the limit of %d", len(tag[1]), limits.MaxInputLength)
+	}
+	if len(tag) < 3 {
+		return nil
+	}
+
+	switch tag[2] {
+	case "", InputTypeText, InputTypeURL:
+	case InputTypeEvent, InputTypeJob:
+		if _, err := ParseEventReference(tag[1]); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown type %q", tag[2])
+	}
+
+	if len(tag) > 3 && tag[3] != "" && !isRelayURL(tag[3]) {
+		return fmt.Errorf("relay %q is not a websocket URL", tag[3])
+	}
+
+	return nil
+}
+
+func isRelayURL(value string) bool {
+	u, err := url.Parse(value)
+
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss") && u.Host != ""
+}
+
+// rejectJobRequest sends an error feedback from every DVM telling why the job request can't be served.
+func (e *Engine) rejectJobRequest(ctx context.Context, dvms []Dvmer, event *goNostr.Event, reason error) {
+	e.log.Printf("reject job request %s %+v", event.ID, reason)
+
+	input := &Nip90Input{
+		JobRequestId:   event.ID,
+		CustomerPubkey: event.PubKey,
+		Event:          event,
+		Relays:         requestRelays(event),
+	}
+	for i := range dvms {
+		err := e.sendFeedbackEvent(ctx, dvms[i], input, &JobUpdate{
+			Status:     StatusError,
+			FailureMsg: reason.Error(),
+		})
+		if err != nil {
+			e.log.Printf("send feedback %+v", err)
+		}
+	}
+}
+
+// requestRelays returns the websocket URLs of the `relays` tags of the job request.
+func requestRelays(event *goNostr.Event) []string {
+	relays := make([]string, 0)
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "relays" {
+			continue
+		}
+		for _, relay := range tag[1:] {
+			if isRelayURL(relay) {
+				relays = append(relays, relay)
+			}
+		}
+	}
+
+	return relays
+}
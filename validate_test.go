@@ -0,0 +1,87 @@
+package godvm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	goNostr "github.com/nbd-wtf/go-nostr"
+)
+
+func TestValidateJobRequest(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	limits := RequestLimits{MaxInputs: 2, MaxInputLength: 64}
+
+	tests := []struct {
+		name      string
+		kind      int
+		createdAt time.Time
+		tags      goNostr.Tags
+		wantErr   bool
+	}{
+		{
+			name: "valid",
+			tags: goNostr.Tags{
+				{"i", "hello", InputTypeText},
+				{"i", id, InputTypeEvent, "wss://relay.example.com"},
+				{"bid", "1000"},
+				{"param", "model", "small"},
+				{"output", "text/plain"},
+				{"relays", "wss://a.example.com", "ws://b.example.com"},
+				{"p", id},
+			},
+		},
+		{name: "input without type", tags: goNostr.Tags{{"i", "hello"}}},
+		{name: "old request", createdAt: time.Now().Add(-24 * time.Hour)},
+		{name: "not a job request kind", kind: 1, wantErr: true},
+		{name: "result kind", kind: 6050, wantErr: true},
+		{name: "created in the future", createdAt: time.Now().Add(time.Hour), wantErr: true},
+		{name: "empty tag", tags: goNostr.Tags{{}}, wantErr: true},
+		{name: "too many inputs", tags: goNostr.Tags{{"i", "a"}, {"i", "b"}, {"i", "c"}}, wantErr: true},
+		{name: "input too long", tags: goNostr.Tags{{"i", id + "!"}}, wantErr: true},
+		{name: "input without value", tags: goNostr.Tags{{"i"}}, wantErr: true},
+		{name: "unknown input type", tags: goNostr.Tags{{"i", "a", "file"}}, wantErr: true},
+		{name: "invalid event input", tags: goNostr.Tags{{"i", "note", InputTypeEvent}}, wantErr: true},
+		{name: "invalid input relay", tags: goNostr.Tags{{"i", "a", InputTypeText, "https://a"}}, wantErr: true},
+		{name: "bid without amount", tags: goNostr.Tags{{"bid"}}, wantErr: true},
+		{name: "negative bid", tags: goNostr.Tags{{"bid", "-1"}}, wantErr: true},
+		{name: "param without value", tags: goNostr.Tags{{"param", "model"}}, wantErr: true},
+		{name: "output without mime type", tags: goNostr.Tags{{"output", ""}}, wantErr: true},
+		{name: "invalid relay", tags: goNostr.Tags{{"relays", "relay.example.com"}}, wantErr: true},
+		{name: "invalid p tag", tags: goNostr.Tags{{"p", "npub"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &goNostr.Event{Kind: tt.kind, CreatedAt: goNostr.Timestamp(tt.createdAt.Unix()), Tags: tt.tags}
+			if event.Kind == 0 {
+				event.Kind = KindReqTextGeneration
+			}
+			if tt.createdAt.IsZero() {
+				event.CreatedAt = goNostr.Now()
+			}
+
+			err := ValidateJobRequest(event, limits)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidJobRequest) {
+				t.Errorf("error %v doesn't wrap %v", err, ErrInvalidJobRequest)
+			}
+		})
+	}
+}
+
+func TestRequestRelays(t *testing.T) {
+	event := &goNostr.Event{Tags: goNostr.Tags{
+		{"relays", "wss://a.example.com", "invalid"},
+		{"relays"},
+		{"relays", "ws://b.example.com"},
+	}}
+
+	got := requestRelays(event)
+	if len(got) != 2 || got[0] != "wss://a.example.com" || got[1] != "ws://b.example.com" {
+		t.Errorf("got relays %v", got)
+	}
+}